@@ -0,0 +1,270 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func tempFile(t testing.TB, dir string, data []byte) *os.File {
+	f, err := ioutil.TempFile(dir, "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+	return f
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestCopyToRegularFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := testData(3<<20 + 17)
+	src := tempFile(t, dir, data)
+	defer src.Close()
+	dst := tempFile(t, dir, nil)
+	defer dst.Close()
+
+	if err := copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(dst.Name())
+	if !bytes.Equal(got, data) {
+		t.Errorf("copied %d bytes, want %d matching bytes", len(got), len(data))
+	}
+}
+
+// Appending rules out copy_file_range, so another method has to take
+// over.
+func TestCopyToAppendedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := testData(1 << 20)
+	src := tempFile(t, dir, data)
+	defer src.Close()
+	name := filepath.Join(dir, "out")
+	ioutil.WriteFile(name, []byte("head"), 0644)
+	dst, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err := copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(name)
+	if !bytes.Equal(got, append([]byte("head"), data...)) {
+		t.Errorf("appended copy doesn't match")
+	}
+}
+
+// Files in /proc claim to be empty, which some kernels' copy_file_range
+// takes at its word.
+func TestCopyFromProc(t *testing.T) {
+	want, err := ioutil.ReadFile("/proc/version")
+	if err != nil || len(want) == 0 {
+		t.Skip("no /proc/version to read")
+	}
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := os.Open("/proc/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst := tempFile(t, dir, nil)
+	defer dst.Close()
+
+	if err := copyFile(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(dst.Name())
+	if !bytes.Equal(got, want) {
+		t.Errorf("copied %q, want %q", got, want)
+	}
+}
+
+func TestCopyThroughPipes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := testData(2<<20 + 3)
+
+	// Regular file into a pipe
+	src := tempFile(t, dir, data)
+	defer src.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		received <- b
+	}()
+	if err := copyFile(w, src); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if got := <-received; !bytes.Equal(got, data) {
+		t.Errorf("file to pipe copied %d bytes, want %d matching bytes", len(got), len(data))
+	}
+	r.Close()
+
+	// Pipe into a regular file
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	dst := tempFile(t, dir, nil)
+	defer dst.Close()
+	if err := copyFile(dst, r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	got, _ := ioutil.ReadFile(dst.Name())
+	if !bytes.Equal(got, data) {
+		t.Errorf("pipe to file copied %d bytes, want %d matching bytes", len(got), len(data))
+	}
+}
+
+// Open a pseudo-terminal pair, returning the controlling side and the
+// terminal itself.
+func openPty() (*os.File, *os.File, error) {
+	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock := 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptm.Fd(),
+		syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		ptm.Close()
+		return nil, nil, errno
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptm.Fd(),
+		syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		ptm.Close()
+		return nil, nil, errno
+	}
+	pts, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptm.Close()
+		return nil, nil, err
+	}
+	return ptm, pts, nil
+}
+
+// A terminal supports none of the kernel copies, so the data has to
+// arrive through the ordinary fallback.
+func TestCopyToTerminal(t *testing.T) {
+	ptm, pts, err := openPty()
+	if err != nil {
+		t.Skipf("no pseudo-terminal available: %v", err)
+	}
+	defer ptm.Close()
+	defer pts.Close()
+
+	// Raw bytes without newlines, so the terminal doesn't translate
+	// anything on the way through
+	data := bytes.Repeat([]byte("x"), 1000)
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := tempFile(t, dir, data)
+	defer src.Close()
+
+	received := make(chan []byte)
+	go func() {
+		got := make([]byte, 0)
+		buf := make([]byte, 4096)
+		for len(got) < len(data) {
+			n, err := ptm.Read(buf)
+			if err != nil {
+				break
+			}
+			got = append(got, buf[:n]...)
+		}
+		received <- got
+	}()
+	if err := copyFile(pts, src); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; !bytes.Equal(got, data) {
+		t.Errorf("copied %q to the terminal, want %q", got, data)
+	}
+}
+
+func benchmarkCopy(b *testing.B, transfer func(dst *os.File, src *os.File)) {
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := testData(64 << 20)
+	src := tempFile(b, dir, data)
+	defer src.Close()
+	dst := tempFile(b, dir, nil)
+	defer dst.Close()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src.Seek(0, io.SeekStart)
+		dst.Seek(0, io.SeekStart)
+		transfer(dst, src)
+	}
+}
+
+func BenchmarkCopyFile(b *testing.B) {
+	benchmarkCopy(b, func(dst *os.File, src *os.File) {
+		copyFile(dst, src)
+	})
+}
+
+func BenchmarkIOCopy(b *testing.B) {
+	benchmarkCopy(b, func(dst *os.File, src *os.File) {
+		io.Copy(dst, src)
+	})
+}
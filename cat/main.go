@@ -13,7 +13,11 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"unicode/utf8"
+	"strconv"
+	"strings"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/number"
 )
 
 type arg struct {
@@ -23,21 +27,41 @@ type arg struct {
 	show_line_endings     bool
 	squeeze_blank         bool
 	show_tabs             bool
+	show_nonprinting      bool
+	numbering             number.Numberer
 }
 
 const (
 	usage_message string = "usage: cat [OPTION ...] [FILE ...]"
 	help_message  string = `Concatenate and print FILE or STDIN to STDOUT.
 
+  -A, --show-all            equivalent to -vET
   -b, --number-nonblank     number only non-blank lines
+  -e                        equivalent to -vE
   -E, --show-ends           print $ at the end of each line
   -n, --number              number output lines, starting with 1
+      --number-format=FORMAT
+                            format line numbers as FORMAT: ln for left
+                                justified, rn for right justified, or rz
+                                for right justified with leading zeros;
+                                default rn
+      --number-increment=N  add N to the line number for each line;
+                                default 1
+      --number-separator=STRING
+                            print STRING between the line number and the
+                                line; default a tab character
+      --number-start=N      number the first line N; default 1
+      --number-width=N      use N columns for line numbers; default 6
   -s, --squeeze-blank       print no more than one consecutive blank line
+  -t                        equivalent to -vT
   -T, --show-tabs           print tab character as ^I
+  -v, --show-nonprinting    use ^ and M- notation for control characters
+                                and bytes above 127, except for tab and
+                                newline
   -h, --help                print this help message and exit
 `
-	tab     rune = 9
-	newline rune = 10
+	tab     byte = 9
+	newline byte = 10
 )
 
 var quick bool = true
@@ -52,74 +76,150 @@ func help() {
 	os.Exit(0)
 }
 
-func cat(file io.Reader, args arg) {
+// The state of the formatting options, carried from one FILE to the next
+// so that numbering and blank line squeezing continue across them as if
+// the files had been joined together first.
+type formatter struct {
+	args       arg
+	number     number.Numberer
+	line_start bool
+	prev_blank bool
+}
+
+func newFormatter(args arg) *formatter {
+	return &formatter{args: args, number: args.numbering, line_start: true}
+}
+
+func cat(file io.Reader, out io.Writer, f *formatter) error {
 	if file == nil {
 		file = os.Stdin
 	}
 
 	if quick {
-		io.Copy(os.Stdout, file)
-		return
+		return copyFile(out, file)
 	}
 
 	r := bufio.NewReader(file)
-	w := bufio.NewWriterSize(os.Stdout, 512)
-
-	line_number := 0
-	newline_next := true
-
-	prev_rune := []rune{0, 0}
+	w := bufio.NewWriterSize(out, 512)
 
 	buf := make([]byte, 512)
 
 	for {
 		n, err := r.Read(buf)
 
+		f.format(w, buf[:n])
+		if werr := w.Flush(); werr != nil {
+			return werr
+		}
+
 		if err == io.EOF {
-			return
+			return nil
 		} else if err != nil {
-			panic(err)
+			return err
 		}
+	}
+}
 
-		for i := 0; i < n; i++ {
-			this_rune, _ := utf8.DecodeRune(buf[i : i+1])
+// Copy file to out unchanged. When both ends are files, io.Copy already
+// has the kernel move the data directly with copy_file_range, sendfile
+// or splice where it can, and falls back to read and write where not.
+func copyFile(out io.Writer, file io.Reader) error {
+	_, err := io.Copy(out, file)
+	return err
+}
 
-			// Identify and squeeze blank lines
-			if args.squeeze_blank && prev_rune[0] == newline {
-				if this_rune == newline && prev_rune[0] == prev_rune[1] {
-					continue
-				}
+// Write buf to w with the formatting options applied. Everything the
+// options act on is a single ASCII byte, so input is handled byte by
+// byte. Multibyte UTF-8 and arbitrary binary pass through untouched
+// unless -v asks for them to be escaped.
+func (f *formatter) format(w *bufio.Writer, buf []byte) {
+	args := f.args
+	for _, c := range buf {
+		// Identify and squeeze blank lines
+		if f.line_start && c == newline {
+			if args.squeeze_blank && f.prev_blank {
+				continue
 			}
+			f.prev_blank = true
+		} else if f.line_start {
+			f.prev_blank = false
+		}
 
-			prev_rune = []rune{this_rune, prev_rune[0]}
-
-			if args.line_numbers && newline_next == true {
-				if this_rune != newline || !args.nonblank_line_numbers {
-					line_number++
-					fmt.Fprintf(w, "%6d\t", line_number)
-					newline_next = false
-				}
+		if args.line_numbers && f.line_start {
+			if c != newline || !args.nonblank_line_numbers {
+				f.number.Write(w)
 			}
+		}
+		f.line_start = c == newline
 
-			if args.show_tabs && this_rune == tab {
-				fmt.Fprintf(w, "^%c", this_rune+64)
-				continue
-			} else if this_rune == newline {
-				newline_next = true
-				if args.show_line_endings {
-					w.Write([]byte("$"))
-				}
+		if args.show_tabs && c == tab {
+			w.WriteString("^I")
+			continue
+		} else if c == newline {
+			if args.show_line_endings {
+				w.WriteByte('$')
 			}
+		} else if args.show_nonprinting && c != tab {
+			writeNonprinting(w, c)
+			continue
+		}
 
-			w.Write([]byte(buf[i : i+1]))
+		w.WriteByte(c)
+	}
+}
+
+func parse_args(args []string, i *int, s string, l string) (arg_v string) {
+	if args[*i] == s || args[*i] == l {
+		if len(args)-1 > *i {
+			*i++
+			return args[*i]
 		}
+		usage("option requires value -- " + args[*i])
+	}
+	if strings.HasPrefix(args[*i], l+"=") {
+		return strings.TrimPrefix(args[*i], l+"=")
+	}
+	if strings.HasPrefix(args[*i], s) {
+		return strings.TrimPrefix(args[*i], s)
+	}
+	return ""
+}
+
+// Whether reading file would feed cat's own output back into itself, as
+// with cat a >> a, which would otherwise never finish. Reading is safe
+// when there is nothing left in file past the current offset.
+func isOutput(file *os.File, out os.FileInfo) bool {
+	if out == nil || !out.Mode().IsRegular() {
+		return false
+	}
+	in, err := file.Stat()
+	if err != nil || !os.SameFile(in, out) {
+		return false
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	return err == nil && offset < in.Size()
+}
 
-		w.Flush()
+// Write c using ^ and M- notation: control characters become ^@ through
+// ^_, DEL becomes ^?, and bytes above 127 are written as M- followed by
+// the notation for their low seven bits.
+func writeNonprinting(w *bufio.Writer, c byte) {
+	if c >= 128 {
+		w.WriteString("M-")
+		c -= 128
+	}
+	if c < 32 {
+		w.WriteByte('^')
+		w.WriteByte(c + 64)
+	} else if c == 127 {
+		w.WriteString("^?")
+	} else {
+		w.WriteByte(c)
 	}
 }
 
 func main() {
-	args := arg{}
+	args := arg{numbering: number.Default}
 	reached_files := false
 
 	for i := 1; i < len(os.Args); i++ {
@@ -127,6 +227,30 @@ func main() {
 			if os.Args[i] == "-h" || os.Args[i] == "--help" {
 				help()
 			}
+			if os.Args[i] == "-A" || os.Args[i] == "--show-all" {
+				args.show_nonprinting = true
+				args.show_line_endings = true
+				args.show_tabs = true
+				quick = false
+				continue
+			}
+			if os.Args[i] == "-e" {
+				args.show_nonprinting = true
+				args.show_line_endings = true
+				quick = false
+				continue
+			}
+			if os.Args[i] == "-t" {
+				args.show_nonprinting = true
+				args.show_tabs = true
+				quick = false
+				continue
+			}
+			if os.Args[i] == "-v" || os.Args[i] == "--show-nonprinting" {
+				args.show_nonprinting = true
+				quick = false
+				continue
+			}
 			if os.Args[i] == "-b" || os.Args[i] == "--number-nonblank" {
 				args.nonblank_line_numbers = true
 				args.line_numbers = true
@@ -143,6 +267,51 @@ func main() {
 				quick = false
 				continue
 			}
+			if arg_v := parse_args(os.Args, &i, "--number-format", "--number-format"); arg_v != "" {
+				if !number.ValidFormat(arg_v) {
+					usage("invalid line number format -- " + arg_v)
+				}
+				args.numbering.Format = arg_v
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "--number-increment", "--number-increment"); arg_v != "" {
+				increment, err := strconv.ParseInt(arg_v, 10, 64)
+				if err != nil {
+					usage("invalid line number increment -- " + arg_v)
+				}
+				args.numbering.Increment = increment
+				continue
+			}
+			// The separator may legitimately be empty, which
+			// parse_args can't tell apart from a missing option
+			if os.Args[i] == "--number-separator" {
+				if i == len(os.Args)-1 {
+					usage("option requires value -- " + os.Args[i])
+				}
+				i++
+				args.numbering.Separator = os.Args[i]
+				continue
+			}
+			if strings.HasPrefix(os.Args[i], "--number-separator=") {
+				args.numbering.Separator = strings.TrimPrefix(os.Args[i], "--number-separator=")
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "--number-start", "--number-start"); arg_v != "" {
+				start, err := strconv.ParseInt(arg_v, 10, 64)
+				if err != nil {
+					usage("invalid starting line number -- " + arg_v)
+				}
+				args.numbering.Next = start
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "--number-width", "--number-width"); arg_v != "" {
+				width, err := strconv.Atoi(arg_v)
+				if err != nil || width < 1 {
+					usage("invalid line number field width -- " + arg_v)
+				}
+				args.numbering.Width = width
+				continue
+			}
 			if os.Args[i] == "-s" || os.Args[i] == "--squeeze-blank" {
 				args.squeeze_blank = true
 				quick = false
@@ -167,18 +336,37 @@ func main() {
 	}
 
 	if len(args.file) == 0 {
-		cat(nil, args)
-	} else {
-		for i := range args.file {
-			if args.file[i] == "-" {
-				cat(nil, args)
-			} else {
-				file, err := os.Open(args.file[i])
-				if err != nil {
-					panic(err)
-				}
-				cat(file, args)
+		args.file = append(args.file, "-")
+	}
+
+	out_info, _ := os.Stdout.Stat()
+
+	// A FILE that can't be read is skipped over, the rest still copied
+	status := 0
+	f := newFormatter(args)
+	for i := range args.file {
+		file := os.Stdin
+		if args.file[i] != "-" {
+			var err error
+			file, err = os.Open(args.file[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cat: %s: %s\n", args.file[i], errmsg.Describe(err))
+				status = 1
+				continue
 			}
 		}
+
+		if isOutput(file, out_info) {
+			fmt.Fprintf(os.Stderr, "cat: %s: input file is output file\n", args.file[i])
+			status = 1
+		} else if err := cat(file, os.Stdout, f); err != nil {
+			fmt.Fprintf(os.Stderr, "cat: %s: %s\n", args.file[i], errmsg.Describe(err))
+			status = 1
+		}
+
+		if file != os.Stdin {
+			file.Close()
+		}
 	}
+	os.Exit(status)
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/number"
+)
+
+// The golden files hold GNU cat's output for each option over every
+// byte value from 0 to 255.
+func TestNonprintingGolden(t *testing.T) {
+	quick = false
+	in, err := ioutil.ReadFile(filepath.Join("testdata", "allbytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := []struct {
+		golden string
+		args   arg
+	}{
+		{"allbytes.v.golden", arg{show_nonprinting: true}},
+		{"allbytes.A.golden", arg{show_nonprinting: true, show_line_endings: true, show_tabs: true}},
+		{"allbytes.e.golden", arg{show_nonprinting: true, show_line_endings: true}},
+		{"allbytes.t.golden", arg{show_nonprinting: true, show_tabs: true}},
+	}
+	for _, o := range options {
+		want, err := ioutil.ReadFile(filepath.Join("testdata", o.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		cat(bytes.NewReader(in), &out, newFormatter(o.args))
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: got\n%q\nwant\n%q", o.golden, out.Bytes(), want)
+		}
+	}
+}
+
+// A straightforward line-at-a-time model of the formatting options, to
+// check the byte-at-a-time implementation against.
+func model(in []byte, args arg) []byte {
+	var out bytes.Buffer
+	line_number := 0
+	prev_blank := false
+	for _, line := range bytes.SplitAfter(in, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		blank := line[0] == '\n'
+		if blank && args.squeeze_blank && prev_blank {
+			continue
+		}
+		prev_blank = blank
+		if args.line_numbers && (!blank || !args.nonblank_line_numbers) {
+			line_number++
+			fmt.Fprintf(&out, "%6d\t", line_number)
+		}
+		for _, c := range line {
+			switch {
+			case c == '\n' && args.show_line_endings:
+				out.WriteString("$\n")
+			case c == '\n':
+				out.WriteByte(c)
+			case c == '\t' && args.show_tabs:
+				out.WriteString("^I")
+			case c == '\t':
+				out.WriteByte(c)
+			case args.show_nonprinting:
+				if c >= 128 {
+					out.WriteString("M-")
+					c -= 128
+				}
+				if c < 32 {
+					out.WriteString("^" + string(rune(c+64)))
+				} else if c == 127 {
+					out.WriteString("^?")
+				} else {
+					out.WriteByte(c)
+				}
+			default:
+				out.WriteByte(c)
+			}
+		}
+	}
+	return out.Bytes()
+}
+
+// Turn the low six bits of opts into a set of formatting options.
+func options(opts uint8) arg {
+	return arg{
+		numbering:             number.Default,
+		nonblank_line_numbers: opts&1 != 0,
+		line_numbers:          opts&3 != 0,
+		show_line_endings:     opts&4 != 0,
+		squeeze_blank:         opts&8 != 0,
+		show_tabs:             opts&16 != 0,
+		show_nonprinting:      opts&32 != 0,
+	}
+}
+
+func checkModel(t *testing.T, in []byte, args arg) {
+	want := model(in, args)
+
+	var out bytes.Buffer
+	cat(bytes.NewReader(in), &out, newFormatter(args))
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("cat(%q, %+v) = %q, want %q", in, args, out.Bytes(), want)
+	}
+
+	// Reads that split lines and characters anywhere must not matter
+	out.Reset()
+	cat(iotest.OneByteReader(bytes.NewReader(in)), &out, newFormatter(args))
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("cat(%q, %+v) one byte at a time = %q, want %q", in, args, out.Bytes(), want)
+	}
+
+	// Nor must splitting the input into separate files
+	for _, split := range []int{0, len(in) / 3, len(in) / 2, len(in)} {
+		out.Reset()
+		f := newFormatter(args)
+		cat(bytes.NewReader(in[:split]), &out, f)
+		cat(bytes.NewReader(in[split:]), &out, f)
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("cat(%q, %q, %+v) = %q, want %q", in[:split], in[split:], args, out.Bytes(), want)
+		}
+	}
+}
+
+func TestMatchesModel(t *testing.T) {
+	quick = false
+	pieces := []string{"\n", "\n\n", "\t", "a", "日本語", "\xe6\x97", "\xff", "\x00", "\x7f", "\x89", " "}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		var in bytes.Buffer
+		for in.Len() < rnd.Intn(2000) {
+			in.WriteString(pieces[rnd.Intn(len(pieces))])
+		}
+		for opts := uint8(0); opts < 64; opts++ {
+			checkModel(t, in.Bytes(), options(opts))
+		}
+	}
+}
+
+// A multibyte character straddling the 512-byte read boundary must come
+// through intact.
+func TestUTF8AcrossReads(t *testing.T) {
+	quick = false
+	in := []byte(strings.Repeat("a", 511) + "日本\n")
+	var out bytes.Buffer
+	cat(bytes.NewReader(in), &out, newFormatter(arg{line_numbers: true, show_line_endings: true, numbering: number.Default}))
+	if want := "     1\t" + strings.Repeat("a", 511) + "日本$\n"; out.String() != want {
+		t.Errorf("cat = %q, want %q", out.String(), want)
+	}
+}
+
+func TestStateAcrossFiles(t *testing.T) {
+	quick = false
+	cases := []struct {
+		files []string
+		args  arg
+		out   string
+	}{
+		// Numbering continues rather than restarting at 1
+		{[]string{"a\nb\n", "c\n"}, arg{line_numbers: true},
+			"     1\ta\n     2\tb\n     3\tc\n"},
+		// A file without a trailing newline runs into the next
+		{[]string{"a\nb", "c\nd"}, arg{line_numbers: true},
+			"     1\ta\n     2\tbc\n     3\td"},
+		{[]string{"a", "", "b\n"}, arg{line_numbers: true, show_line_endings: true},
+			"     1\tab$\n"},
+		// Blank runs are squeezed across the boundary
+		{[]string{"a\n\n", "\n\nb\n"}, arg{squeeze_blank: true},
+			"a\n\nb\n"},
+		{[]string{"\n", "\n", "\n"}, arg{squeeze_blank: true, line_numbers: true},
+			"     1\t\n"},
+		{[]string{"a\n\n", "\nb"}, arg{line_numbers: true, nonblank_line_numbers: true},
+			"     1\ta\n\n\n     2\tb"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		c.args.numbering = number.Default
+		f := newFormatter(c.args)
+		for _, file := range c.files {
+			cat(strings.NewReader(file), &out, f)
+		}
+		if out.String() != c.out {
+			t.Errorf("cat(%q, %+v) = %q, want %q", c.files, c.args, out.String(), c.out)
+		}
+	}
+}
+
+func TestNumberingOptions(t *testing.T) {
+	quick = false
+	cases := []struct {
+		numbering number.Numberer
+		out       string
+	}{
+		{number.Default, "     1\ta\n     2\tb\n     3\tc\n"},
+		{number.Numberer{Width: 3, Format: "ln", Separator: " | ", Next: 1, Increment: 1},
+			"1   | a\n2   | b\n3   | c\n"},
+		{number.Numberer{Width: 4, Format: "rz", Separator: ":", Next: 9, Increment: 2},
+			"0009:a\n0011:b\n0013:c\n"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		cat(strings.NewReader("a\nb\nc\n"), &out, newFormatter(arg{line_numbers: true, numbering: c.numbering}))
+		if out.String() != c.out {
+			t.Errorf("cat -n with %+v = %q, want %q", c.numbering, out.String(), c.out)
+		}
+	}
+}
+
+func TestIsOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	empty := filepath.Join(dir, "empty")
+	ioutil.WriteFile(a, []byte("a\n"), 0644)
+	ioutil.WriteFile(b, []byte("b\n"), 0644)
+	ioutil.WriteFile(empty, nil, 0644)
+
+	cases := []struct {
+		in  string
+		out string
+		is  bool
+	}{
+		{a, a, true},
+		{a, b, false},
+		{empty, empty, false},
+	}
+	for _, c := range cases {
+		out, _ := os.OpenFile(c.out, os.O_WRONLY|os.O_APPEND, 0644)
+		out_info, _ := out.Stat()
+		in, _ := os.Open(c.in)
+		if got := isOutput(in, out_info); got != c.is {
+			t.Errorf("isOutput(%s, %s) = %v, want %v", c.in, c.out, got, c.is)
+		}
+		in.Close()
+		out.Close()
+	}
+
+	// Output that isn't a regular file never counts
+	in, _ := os.Open(a)
+	defer in.Close()
+	null_info, _ := os.Stat(os.DevNull)
+	if isOutput(in, null_info) {
+		t.Errorf("isOutput(%s, %s) = true, want false", a, os.DevNull)
+	}
+}
+
+func FuzzCat(f *testing.F) {
+	quick = false
+	f.Add([]byte("a\n\n\nb\tc\n"), uint8(63))
+	f.Add([]byte("日本語\n\xff\x00\n"), uint8(32))
+	f.Add([]byte("\n\n\n"), uint8(9))
+	f.Fuzz(func(t *testing.T, in []byte, opts uint8) {
+		checkModel(t, in, options(opts))
+	})
+}
+
+func TestDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Both the plain copy and the formatting path report the error
+	for _, q := range []bool{true, false} {
+		quick = q
+		file, err := os.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err = cat(file, &out, newFormatter(arg{line_numbers: !q, numbering: number.Default}))
+		if err == nil || errmsg.Describe(err) != "Is a directory" || out.Len() != 0 {
+			t.Errorf("cat(%s) quick %v = %q, %v; want Is a directory", dir, q, out.String(), err)
+		}
+		file.Close()
+	}
+}
+
+// A writer that fails the way a full disk does.
+type fullWriter struct{}
+
+func (fullWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestWriteError(t *testing.T) {
+	// Both the plain copy and the formatting path report the error
+	for _, q := range []bool{true, false} {
+		quick = q
+		err := cat(strings.NewReader("a\nb\n"), fullWriter{}, newFormatter(arg{line_numbers: !q, numbering: number.Default}))
+		if err == nil {
+			t.Errorf("cat to a full writer, quick %v, returned no error", q)
+		}
+	}
+}
@@ -9,20 +9,24 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/size"
 )
 
 type arg struct {
-	count   int
-	bytes   int
-	quiet   bool
-	verbose bool
-	file    []string
+	count        int64
+	bytes        bool
+	all_but_last bool
+	delimiter    byte
+	quiet        bool
+	verbose      bool
+	file         []string
 }
 
 const (
@@ -31,12 +35,20 @@ const (
 A header describing the file name is prefixed when multiple files are passed
 in. When no FILE is provided, read from STDIN.
 
-  -c, --bytes=N             print the first N bytes of FILE or STDIN
-  -n, --lines=N             print the first N lines of FILE or STDIN;
-                                default 10
+  -c, --bytes=[-]N          print the first N bytes of FILE or STDIN;
+                                with a leading '-', print all but the last
+                                N bytes
+  -n, --lines=[-]N          print the first N lines of FILE or STDIN;
+                                with a leading '-', print all but the last
+                                N lines; default 10
   -q, --quiet, --silent     don't print file name headers
   -v, --verbose             always print file name headers
+  -z, --zero-terminated     line delimiter is NUL, not newline
   -h, --help                print this help message and exit
+
+N may have a multiplier suffix: b 512, kB 1000, K 1024, MB 1000*1000,
+M 1024*1024, GB 1000*1000*1000, G 1024*1024*1024, and so on for T, P and
+E. Binary prefixes may also be written as KiB, MiB, and so on.
 `
 )
 
@@ -51,66 +63,189 @@ func help() {
 }
 
 func parse_args(args []string, i *int, s string, l string) (arg_v string) {
-	if strings.HasPrefix(args[*i], s) || strings.HasPrefix(args[*i], l) {
-		arg_v := strings.Trim(args[*i], l)
-		if len(arg_v) == 0 && len(args)-1 > *i {
-			*i++
-			arg_v = args[*i]
-			return arg_v
-		} else if strings.HasPrefix(arg_v, "=") {
-			arg_v = arg_v[1:len(arg_v)]
-			return arg_v
-		}
-
-		arg_v = strings.Trim(args[*i], s)
-		if len(arg_v) == 0 && len(args)-1 > *i {
+	if args[*i] == s || args[*i] == l {
+		if len(args)-1 > *i {
 			*i++
-			arg_v = args[*i]
-		} else if len(arg_v) == 0 {
-			usage("option requires value -- " + args[*i])
+			return args[*i]
 		}
-		return arg_v
+		usage("option requires value -- " + args[*i])
+	}
+	if strings.HasPrefix(args[*i], l+"=") {
+		return strings.TrimPrefix(args[*i], l+"=")
+	}
+	if strings.HasPrefix(args[*i], s) {
+		return strings.TrimPrefix(args[*i], s)
 	}
 	return ""
 }
 
-func head(file io.Reader, args arg) {
+// Parse a count passed to -c or -n, with an optional multiplier suffix.
+// A leading '-' asks for everything except the last N bytes or lines.
+func parse_count(s string) (count int64, all_but_last bool, err error) {
+	if strings.HasPrefix(s, "-") {
+		all_but_last = true
+		s = s[1:]
+	}
+	count, err = size.Parse(s)
+	return count, all_but_last, err
+}
+
+func head(file io.Reader, out io.Writer, args arg) error {
 	if file == nil {
 		file = os.Stdin
 	}
 
-	r := bufio.NewReader(file)
-	if args.bytes > 0 {
-		// Create a buffer to fill with the number of bytes
-		// requested
-		var buffer bytes.Buffer
-		for b := 0; b < args.bytes; b++ {
-			c, err := r.ReadByte()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				panic(err)
+	w := bufio.NewWriter(out)
+
+	// Byte counts bypass the line reader entirely and stream
+	// straight through to STDOUT
+	var err error
+	if args.bytes && args.all_but_last {
+		err = headAllButLastBytes(file, w, args.count)
+	} else if args.bytes {
+		err = headBytes(file, w, args.count)
+	} else if args.all_but_last {
+		err = headAllButLastLines(bufio.NewReader(file), w, args.count, args.delimiter)
+	} else {
+		err = headLines(bufio.NewReader(file), w, args.count, args.delimiter)
+	}
+
+	// Whatever is still buffered goes out even after a read error
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Write the first n lines of r, each ending with delim.
+func headLines(r *bufio.Reader, w io.Writer, n int64, delim byte) error {
+	for i := int64(0); i < n; i++ {
+		l, err := r.ReadBytes(delim)
+		if _, werr := w.Write(l); werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the first n bytes of r. The copy is bounded by n and goes
+// through a fixed-size buffer, so memory use stays flat however large n
+// is, and w may hand the copy off to the kernel where it is able to.
+func headBytes(r io.Reader, w io.Writer, n int64) error {
+	_, err := io.CopyN(w, r, n)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// Write everything but the last n bytes of r. Only the trailing n bytes
+// are held back, so arbitrarily large streams never sit in memory. The
+// held bytes are flushed in blocks once at least n more have come in, so
+// moving the last n to the front of the buffer is paid for by the bytes
+// written, and the buffer never grows past 2n plus one read.
+func headAllButLastBytes(r io.Reader, w io.Writer, n int64) error {
+	buf := make([]byte, 32*1024)
+	held := make([]byte, 0)
+	for {
+		c, err := r.Read(buf)
+		held = append(held, buf[:c]...)
+		if over := int64(len(held)) - n; over > 0 && (over >= n || err != nil) {
+			if _, werr := w.Write(held[:over]); werr != nil {
+				return werr
 			}
-			buffer.WriteByte(c)
+			held = held[:copy(held, held[over:])]
 		}
-		os.Stdout.Write([]byte(buffer.String()))
-	} else {
-		// Write out each line until we reach the number of
-		// lines requested
-		for l := 0; l < args.count; l++ {
-			l, err := r.ReadBytes('\n')
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				panic(err)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Write everything but the last n lines of r, each ending with delim.
+// The trailing lines are kept in a ring that grows to at most n entries;
+// once full, the oldest line is written out as each new one arrives.
+func headAllButLastLines(r *bufio.Reader, w io.Writer, n int64, delim byte) error {
+	ring := make([][]byte, 0)
+	pos := 0
+	for {
+		l, err := r.ReadBytes(delim)
+		var werr error
+		if len(l) > 0 {
+			if n == 0 {
+				_, werr = w.Write(l)
+			} else if int64(len(ring)) < n {
+				ring = append(ring, l)
+			} else {
+				_, werr = w.Write(ring[pos])
+				ring[pos] = l
+				pos = (pos + 1) % len(ring)
 			}
-			os.Stdout.Write([]byte(string(l)))
+		}
+		if werr != nil {
+			return werr
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Print the head of each FILE in args to stdout, with headers where
+// they're called for, and return the exit status.
+func headFiles(args arg, stdout io.Writer, stderr io.Writer) int {
+	// Problems with one FILE are reported and the rest are still
+	// processed; the exit status records that something went wrong.
+	status := 0
+	printed_header := false
+	for i := range args.file {
+		var file io.Reader
+		name := args.file[i]
+		if name == "-" {
+			name = "standard input"
+		} else {
+			f, err := os.Open(args.file[i])
+			if err != nil {
+				fmt.Fprintf(stderr, "head: %s: %s\n", args.file[i], errmsg.Describe(err))
+				status = 1
+				continue
+			}
+			file = f
+		}
+
+		// Print headers for the filenames if we are handling
+		// multiple files
+		if len(args.file) > 1 && !args.quiet || args.verbose {
+			if printed_header {
+				fmt.Fprintf(stdout, "\n==> %s <==\n", name)
+			} else {
+				fmt.Fprintf(stdout, "==> %s <==\n", name)
+			}
+			printed_header = true
+		}
+
+		if err := head(file, stdout, args); err != nil {
+			fmt.Fprintf(stderr, "head: %s: %s\n", args.file[i], errmsg.Describe(err))
+			status = 1
+		}
+		if f, ok := file.(*os.File); ok {
+			f.Close()
 		}
 	}
+	return status
 }
 
 func main() {
-	args := arg{10, 0, false, false, []string{}}
+	args := arg{10, false, false, '\n', false, false, []string{}}
 	reached_files := false
 	for i := 1; i < len(os.Args); i++ {
 		if reached_files == false {
@@ -118,20 +253,22 @@ func main() {
 			if os.Args[i] == "-h" || os.Args[i] == "--help" {
 				help()
 			}
-			arg_v := parse_args(os.Args, &i, "-n", "--count")
+			arg_v := parse_args(os.Args, &i, "-n", "--lines")
 			if arg_v != "" {
-				args.count, err = strconv.Atoi(arg_v)
+				args.count, args.all_but_last, err = parse_count(arg_v)
 				if err != nil {
-					usage("illegal option " + os.Args[i])
+					usage(fmt.Sprintf("invalid number of lines (%s) -- %s", err, arg_v))
 				}
+				args.bytes = false
 				continue
 			}
 			arg_v = parse_args(os.Args, &i, "-c", "--bytes")
 			if arg_v != "" {
-				args.bytes, err = strconv.Atoi(arg_v)
+				args.count, args.all_but_last, err = parse_count(arg_v)
 				if err != nil {
-					usage("illegal option " + os.Args[i])
+					usage(fmt.Sprintf("invalid number of bytes (%s) -- %s", err, arg_v))
 				}
+				args.bytes = true
 				continue
 			}
 			if os.Args[i] == "-q" || os.Args[i] == "--quiet" || os.Args[i] == "--silent" {
@@ -142,12 +279,21 @@ func main() {
 				args.verbose = true
 				continue
 			}
-			arg_v = parse_args(os.Args, &i, "-", "-")
-			if arg_v != "" {
-				args.count, err = strconv.Atoi(arg_v)
-				if err != nil {
+			if os.Args[i] == "-z" || os.Args[i] == "--zero-terminated" {
+				args.delimiter = 0
+				continue
+			}
+			if os.Args[i] == "--" {
+				reached_files = true
+				continue
+			}
+			if len(os.Args[i]) > 1 && strings.HasPrefix(os.Args[i], "-") && !strings.HasPrefix(os.Args[i], "--") {
+				args.count, err = strconv.ParseInt(os.Args[i][1:], 10, 64)
+				if err != nil || args.count < 0 {
 					usage("illegal option " + os.Args[i])
 				}
+				args.all_but_last = false
+				args.bytes = false
 				continue
 			}
 		}
@@ -157,23 +303,8 @@ func main() {
 	}
 
 	if len(args.file) == 0 {
-		head(nil, args)
-	} else {
-		for i := range args.file {
-			// Print headers for the filenames if we are handling
-			// multiple files
-			if len(args.file) > 1 && !args.quiet || args.verbose {
-				if i > 0 {
-					fmt.Fprintf(os.Stdout, "\n==> %s <==\n", args.file[i])
-				} else {
-					fmt.Fprintf(os.Stdout, "==> %s <==\n", args.file[i])
-				}
-			}
-			file, err := os.Open(args.file[i])
-			if err != nil {
-				panic(err)
-			}
-			head(file, args)
-		}
+		args.file = append(args.file, "-")
 	}
+
+	os.Exit(headFiles(args, os.Stdout, os.Stderr))
 }
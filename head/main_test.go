@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/trevorparker/goutils/internal/size"
+)
+
+func TestParseCount(t *testing.T) {
+	counts := []struct {
+		in           string
+		count        int64
+		all_but_last bool
+		err          error
+	}{
+		{"10", 10, false, nil},
+		{"-5", 5, true, nil},
+		{"0", 0, false, nil},
+		{"1K", 1024, false, nil},
+		{"-1K", 1024, true, nil},
+		{"8E", 0, false, size.ErrTooLarge},
+		{"-10X", 0, true, size.ErrInvalidSuffix},
+		{"", 0, false, size.ErrInvalid},
+		{"--1", 0, true, size.ErrInvalid},
+		{"+1", 0, false, size.ErrInvalid},
+	}
+	for _, c := range counts {
+		count, all_but_last, err := parse_count(c.in)
+		if err != c.err {
+			t.Errorf("parse_count(%q) error = %v, want %v", c.in, err, c.err)
+			continue
+		}
+		if err == nil && (count != c.count || all_but_last != c.all_but_last) {
+			t.Errorf("parse_count(%q) = %d, %v, want %d, %v",
+				c.in, count, all_but_last, c.count, c.all_but_last)
+		}
+	}
+}
+
+func TestHeadAllButLastLines(t *testing.T) {
+	cases := []struct {
+		in    string
+		n     int64
+		delim byte
+		out   string
+	}{
+		{"1\n2\n3\n4\n", 2, '\n', "1\n2\n"},
+		{"1\n2\n3\n4", 1, '\n', "1\n2\n3\n"},
+		{"1\n2\n", 5, '\n', ""},
+		{"1\n2\n", 0, '\n', "1\n2\n"},
+		{"a\x00b\x00c\x00", 1, 0, "a\x00b\x00"},
+		{"a\nb\x00c\nd\x00", 1, 0, "a\nb\x00"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		r := bufio.NewReader(strings.NewReader(c.in))
+		if err := headAllButLastLines(r, &out, c.n, c.delim); err != nil {
+			t.Errorf("headAllButLastLines(%q, %d) error: %v", c.in, c.n, err)
+		}
+		if out.String() != c.out {
+			t.Errorf("headAllButLastLines(%q, %d) = %q, want %q", c.in, c.n, out.String(), c.out)
+		}
+	}
+}
+
+func TestHeadAllButLastBytes(t *testing.T) {
+	long := strings.Repeat("0123456789", 10000)
+	cases := []struct {
+		in  string
+		n   int64
+		out string
+	}{
+		{"abcdef", 2, "abcd"},
+		{"abcdef", 0, "abcdef"},
+		{"abcdef", 6, ""},
+		{"abc", 10, ""},
+		{"", 3, ""},
+		{long, 1, long[:len(long)-1]},
+		{long, 40000, long[:len(long)-40000]},
+		{long, 99999, "0"},
+	}
+	for _, c := range cases {
+		// Reading a byte at a time as well checks bytes that arrive
+		// short of a flush are still written at the end
+		readers := []io.Reader{strings.NewReader(c.in), iotest.OneByteReader(strings.NewReader(c.in))}
+		for _, r := range readers {
+			var out bytes.Buffer
+			if err := headAllButLastBytes(r, &out, c.n); err != nil {
+				t.Errorf("headAllButLastBytes(%d bytes, %d) error: %v", len(c.in), c.n, err)
+			}
+			if out.String() != c.out {
+				t.Errorf("headAllButLastBytes(%d bytes, %d) = %d bytes, want %d",
+					len(c.in), c.n, out.Len(), len(c.out))
+			}
+		}
+	}
+}
+
+func TestHeadBytes(t *testing.T) {
+	cases := []struct {
+		in  string
+		n   int64
+		out string
+	}{
+		{"abcdef", 3, "abc"},
+		{"abcdef", 0, ""},
+		{"abc", 10, "abc"},
+		{"", 10, ""},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if err := headBytes(strings.NewReader(c.in), &out, c.n); err != nil {
+			t.Errorf("headBytes(%q, %d) error: %v", c.in, c.n, err)
+		}
+		if out.String() != c.out {
+			t.Errorf("headBytes(%q, %d) = %q, want %q", c.in, c.n, out.String(), c.out)
+		}
+	}
+}
+
+// An endless stream of zero bytes, so benchmarks can read far more input
+// than they could ever hold in memory.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Allocations per operation should stay constant as the byte count grows.
+func BenchmarkHeadBytes(b *testing.B) {
+	for _, size := range []int64{1 << 10, 1 << 20, 64 << 20, 1 << 30} {
+		b.Run(strconv.FormatInt(size, 10), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				headBytes(zeroReader{}, ioutil.Discard, size)
+			}
+		})
+	}
+}
+
+// Time per byte should stay flat as the number held back grows.
+func BenchmarkHeadAllButLastBytes(b *testing.B) {
+	const size = 64 << 20
+	for _, n := range []int64{1 << 10, 1 << 20, 8 << 20} {
+		b.Run(strconv.FormatInt(n, 10), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r := io.LimitReader(zeroReader{}, size)
+				headAllButLastBytes(r, ioutil.Discard, n)
+			}
+		})
+	}
+}
+
+func TestHeadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	missing := filepath.Join(dir, "missing")
+	ioutil.WriteFile(a, []byte("a1\na2\n"), 0644)
+	ioutil.WriteFile(b, []byte("b1\n"), 0644)
+
+	cases := []struct {
+		files  []string
+		quiet  bool
+		stdout string
+		stderr string
+		status int
+	}{
+		{[]string{a}, false, "a1\na2\n", "", 0},
+		{[]string{a, b}, false, "==> " + a + " <==\na1\na2\n\n==> " + b + " <==\nb1\n", "", 0},
+		{[]string{a, b}, true, "a1\na2\nb1\n", "", 0},
+		{[]string{missing}, false, "", "head: " + missing + ": No such file or directory\n", 1},
+		{[]string{missing, b}, false, "==> " + b + " <==\nb1\n",
+			"head: " + missing + ": No such file or directory\n", 1},
+		{[]string{a, dir, b}, true, "a1\na2\nb1\n", "head: " + dir + ": Is a directory\n", 1},
+	}
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		args := arg{count: 10, delimiter: '\n', quiet: c.quiet, file: c.files}
+		status := headFiles(args, &stdout, &stderr)
+		if stdout.String() != c.stdout || stderr.String() != c.stderr || status != c.status {
+			t.Errorf("head %v = %q, %q, status %d; want %q, %q, status %d", c.files,
+				stdout.String(), stderr.String(), status, c.stdout, c.stderr, c.status)
+		}
+	}
+}
+
+// A writer that fails the way a full disk does.
+type fullWriter struct{}
+
+func (fullWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestWriteError(t *testing.T) {
+	in := strings.Repeat("line\n", 100000)
+	cases := []arg{
+		{count: 10, delimiter: '\n'},
+		{count: 100000, delimiter: '\n'},
+		{count: 10, delimiter: '\n', all_but_last: true},
+		{count: 0, delimiter: '\n', all_but_last: true},
+		{count: 10, bytes: true},
+		{count: 10, bytes: true, all_but_last: true},
+	}
+	for _, args := range cases {
+		if err := head(strings.NewReader(in), fullWriter{}, args); err == nil {
+			t.Errorf("head %+v to a full writer returned no error", args)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+// Package errmsg words errors for the messages the utilities print.
+package errmsg
+
+import (
+	"os"
+	"strings"
+)
+
+// Describe returns err the way coreutils words it, e.g. "No such file or
+// directory", without the operation and path that os errors carry.
+func Describe(err error) string {
+	if e, ok := err.(*os.PathError); ok {
+		err = e.Err
+	}
+	msg := err.Error()
+	if len(msg) > 0 {
+		msg = strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return msg
+}
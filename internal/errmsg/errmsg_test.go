@@ -0,0 +1,25 @@
+package errmsg
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&os.PathError{Op: "open", Path: "foo", Err: syscall.ENOENT}, "No such file or directory"},
+		{&os.PathError{Op: "read", Path: "/tmp", Err: syscall.EISDIR}, "Is a directory"},
+		{errors.New("input file is output file"), "Input file is output file"},
+		{errors.New(""), ""},
+	}
+	for _, c := range cases {
+		if got := Describe(c.err); got != c.want {
+			t.Errorf("Describe(%v) = %q, want %q", c.err, got, c.want)
+		}
+	}
+}
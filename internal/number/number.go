@@ -0,0 +1,56 @@
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+// Package number formats line numbers for cat and nl.
+package number
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Numberer writes line numbers in the style of nl: each is formatted
+// to Width according to Format, one of ln, rn or rz, and followed by
+// Separator. Numbers count up from Next by Increment.
+type Numberer struct {
+	Width     int
+	Format    string
+	Separator string
+	Next      int64
+	Increment int64
+}
+
+// Default is the numbering used by cat -n and nl when no options are
+// given: six right justified columns followed by a tab.
+var Default = Numberer{6, "rn", "\t", 1, 1}
+
+// ValidFormat reports whether format is one of ln, for left justified,
+// rn, for right justified, or rz, for right justified with leading
+// zeros.
+func ValidFormat(format string) bool {
+	return format == "ln" || format == "rn" || format == "rz"
+}
+
+// Write writes the next line number and its separator.
+func (n *Numberer) Write(w io.Writer) {
+	switch n.Format {
+	case "ln":
+		fmt.Fprintf(w, "%-*d%s", n.Width, n.Next, n.Separator)
+	case "rz":
+		fmt.Fprintf(w, "%0*d%s", n.Width, n.Next, n.Separator)
+	default:
+		fmt.Fprintf(w, "%*d%s", n.Width, n.Next, n.Separator)
+	}
+	n.Next += n.Increment
+}
+
+// Blank writes the spaces that stand in for a number on lines that
+// aren't numbered, so that they line up with those that are.
+func (n *Numberer) Blank(w io.Writer) {
+	io.WriteString(w, strings.Repeat(" ", n.Width+len(n.Separator)))
+}
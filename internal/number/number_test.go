@@ -0,0 +1,41 @@
+package number
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		n   Numberer
+		out string
+	}{
+		{Default, "     1\t     2\t     3\t"},
+		{Numberer{3, "ln", " | ", 1, 1}, "1   | 2   | 3   | "},
+		{Numberer{4, "rz", ":", 1, 1}, "0001:0002:0003:"},
+		{Numberer{2, "rn", "", 98, 1}, "9899100"},
+		{Numberer{3, "rn", " ", 10, -5}, " 10   5   0 "},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		n := c.n
+		for i := 0; i < 3; i++ {
+			n.Write(&out)
+		}
+		if out.String() != c.out {
+			t.Errorf("%+v wrote %q, want %q", c.n, out.String(), c.out)
+		}
+	}
+}
+
+func TestBlank(t *testing.T) {
+	var out bytes.Buffer
+	n := Numberer{4, "rn", ": ", 1, 1}
+	n.Blank(&out)
+	if out.String() != "      " {
+		t.Errorf("Blank wrote %q, want 6 spaces", out.String())
+	}
+	if n.Next != 1 {
+		t.Errorf("Blank moved the next number on to %d", n.Next)
+	}
+}
@@ -0,0 +1,83 @@
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+// Package size parses the byte and line counts given to head and tail.
+package size
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Multipliers accepted as a suffix on a count, following GNU coreutils:
+// a bare letter or "iB" means powers of 1024, "B" means powers of 1000,
+// and "b" means 512-byte blocks.
+var suffixes = map[string]int64{
+	"b":   512,
+	"kB":  1000,
+	"K":   1 << 10,
+	"KiB": 1 << 10,
+	"MB":  1000 * 1000,
+	"M":   1 << 20,
+	"MiB": 1 << 20,
+	"GB":  1000 * 1000 * 1000,
+	"G":   1 << 30,
+	"GiB": 1 << 30,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"T":   1 << 40,
+	"TiB": 1 << 40,
+	"PB":  1000 * 1000 * 1000 * 1000 * 1000,
+	"P":   1 << 50,
+	"PiB": 1 << 50,
+	"EB":  1000 * 1000 * 1000 * 1000 * 1000 * 1000,
+	"E":   1 << 60,
+	"EiB": 1 << 60,
+}
+
+var (
+	ErrInvalid       = errors.New("invalid count")
+	ErrInvalidSuffix = errors.New("invalid suffix")
+	ErrTooLarge      = errors.New("value too large")
+)
+
+// Parse parses an unsigned decimal count with an optional multiplier
+// suffix, e.g. 10, 2K or 1MB. Signs are left to the caller, since head
+// and tail give them different meanings.
+func Parse(s string) (int64, error) {
+	digits := strings.IndexFunc(s, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if digits == -1 {
+		digits = len(s)
+	}
+	if digits == 0 {
+		return 0, ErrInvalid
+	}
+
+	count, err := strconv.ParseInt(s[:digits], 10, 64)
+	if err != nil {
+		if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+			return 0, ErrTooLarge
+		}
+		return 0, ErrInvalid
+	}
+
+	if suffix := s[digits:]; suffix != "" {
+		multiplier, ok := suffixes[suffix]
+		if !ok {
+			return 0, ErrInvalidSuffix
+		}
+		if count > math.MaxInt64/multiplier {
+			return 0, ErrTooLarge
+		}
+		count *= multiplier
+	}
+
+	return count, nil
+}
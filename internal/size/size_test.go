@@ -0,0 +1,35 @@
+package size
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	counts := []struct {
+		in    string
+		count int64
+		err   error
+	}{
+		{"10", 10, nil},
+		{"0", 0, nil},
+		{"2b", 1024, nil},
+		{"1kB", 1000, nil},
+		{"1K", 1024, nil},
+		{"3KiB", 3072, nil},
+		{"10M", 10 << 20, nil},
+		{"2GB", 2000000000, nil},
+		{"7E", 7 << 60, nil},
+		{"8E", 0, ErrTooLarge},
+		{"99999999999999999999", 0, ErrTooLarge},
+		{"10X", 0, ErrInvalidSuffix},
+		{"10k", 0, ErrInvalidSuffix},
+		{"K", 0, ErrInvalid},
+		{"", 0, ErrInvalid},
+		{"-1", 0, ErrInvalid},
+		{"+1", 0, ErrInvalid},
+	}
+	for _, c := range counts {
+		count, err := Parse(c.in)
+		if err != c.err || count != c.count {
+			t.Errorf("Parse(%q) = %d, %v, want %d, %v", c.in, count, err, c.count, c.err)
+		}
+	}
+}
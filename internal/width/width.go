@@ -0,0 +1,87 @@
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+// Package width measures text in terminal columns for wc and ls.
+package width
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Characters that take up two columns on a terminal: the East Asian Wide
+// and Fullwidth ranges, along with emoji that are presented wide.
+var wide = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1},
+		{0x231a, 0x231b, 1},
+		{0x2329, 0x232a, 1},
+		{0x23e9, 0x23ec, 1},
+		{0x25fd, 0x25fe, 1},
+		{0x2614, 0x2615, 1},
+		{0x2e80, 0x303e, 1},
+		{0x3041, 0x33ff, 1},
+		{0x3400, 0x4dbf, 1},
+		{0x4e00, 0x9fff, 1},
+		{0xa000, 0xa4cf, 1},
+		{0xa960, 0xa97f, 1},
+		{0xac00, 0xd7a3, 1},
+		{0xf900, 0xfaff, 1},
+		{0xfe10, 0xfe19, 1},
+		{0xfe30, 0xfe6f, 1},
+		{0xff00, 0xff60, 1},
+		{0xffe0, 0xffe6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x16fe0, 0x16fe4, 1},
+		{0x17000, 0x18cff, 1},
+		{0x1b000, 0x1b2ff, 1},
+		{0x1f004, 0x1f004, 1},
+		{0x1f0cf, 0x1f0cf, 1},
+		{0x1f18e, 0x1f18e, 1},
+		{0x1f191, 0x1f19a, 1},
+		{0x1f200, 0x1f251, 1},
+		{0x1f300, 0x1f64f, 1},
+		{0x1f680, 0x1f6ff, 1},
+		{0x1f900, 0x1f9ff, 1},
+		{0x1fa70, 0x1faff, 1},
+		{0x20000, 0x2fffd, 1},
+		{0x30000, 0x3fffd, 1},
+	},
+}
+
+// Rune returns the number of terminal columns r occupies. Control
+// characters and combining marks take up none.
+func Rune(r rune) int {
+	if r >= 0x20 && r < 0x7f {
+		return 1
+	}
+	if r < 0x20 || r >= 0x7f && r < 0xa0 || r == 0x200b ||
+		unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	if unicode.Is(wide, r) {
+		return 2
+	}
+	return 1
+}
+
+// String returns the number of terminal columns s occupies. Bytes that
+// aren't valid UTF-8 are counted as one column each.
+func String(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			n++
+		} else {
+			n += Rune(r)
+		}
+		s = s[size:]
+	}
+	return n
+}
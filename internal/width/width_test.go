@@ -0,0 +1,45 @@
+package width
+
+import "testing"
+
+func TestRune(t *testing.T) {
+	widths := []struct {
+		r     rune
+		width int
+	}{
+		{'a', 1},
+		{'\x00', 0},
+		{'\u00e9', 1},
+		{'\u0301', 0},
+		{'\u200b', 0},
+		{'日', 2},
+		{'한', 2},
+		{'Ａ', 2},
+		{'ｱ', 1},
+		{'😀', 2},
+	}
+	for _, w := range widths {
+		if got := Rune(w.r); got != w.width {
+			t.Errorf("Rune(%U) = %d, want %d", w.r, got, w.width)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	widths := []struct {
+		s     string
+		width int
+	}{
+		{"", 0},
+		{"file.txt", 8},
+		{"caf\u00e9", 4},
+		{"cafe\u0301", 4},
+		{"日本語.txt", 10},
+		{"\xff\xfe", 2},
+	}
+	for _, w := range widths {
+		if got := String(w.s); got != w.width {
+			t.Errorf("String(%q) = %d, want %d", w.s, got, w.width)
+		}
+	}
+}
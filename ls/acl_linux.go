@@ -0,0 +1,33 @@
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Whether path carries a POSIX access control list beyond its plain
+// permission bits. Symlinks are never followed.
+func hasACL(path string) bool {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return false
+	}
+	for _, attr := range []string{"system.posix_acl_access", "system.posix_acl_default"} {
+		a, _ := syscall.BytePtrFromString(attr)
+		size, _, errno := syscall.Syscall6(syscall.SYS_LGETXATTR,
+			uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(a)), 0, 0, 0, 0)
+		if errno == 0 && size > 0 {
+			return true
+		}
+	}
+	return false
+}
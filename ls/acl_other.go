@@ -0,0 +1,15 @@
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+//go:build !linux
+
+package main
+
+// Access control lists are only detected on Linux.
+func hasACL(path string) bool {
+	return false
+}
@@ -11,33 +11,73 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"unsafe"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/width"
 )
 
 type arg struct {
 	file            []string
+	all             bool
 	almost_all      bool
 	ignore_backups  bool
 	comma_separated bool
 	quote_name      bool
 	one_per_line    bool
+	long            bool
+	sort_by         string
+	reverse         bool
+	recursive       bool
+	directory       bool
+	across          bool
+	line_width      int // 0 for the terminal's width, negative for no limit
 }
 
 const (
 	usage_message string = "usage: ls [OPTION ...] [FILE ...]"
 	help_message  string = `List files and directories, and information about them.
 
+  -a, --all             include entries beginning with a dot, . and ..
+                        among them
   -A, --almost-all      include entries beginning with a dot, except
                         implied . and ..
   -B, --ignore-backups  do not list entries ending with ~
+  -C                    list entries in columns, sorted down each column;
+                        the default
+  -d, --directory       list directories themselves, not their contents
+  -l                    print entries in the long listing format, with
+                        permissions, link count, owner, group, size and
+                        modification time
   -m                    print a comma-separated list of entries
   -Q, --quote-name      print each entry surrounded by double quotes
+  -r, --reverse         reverse the order entries are sorted in
+  -R, --recursive       list the contents of subdirectories as well, each
+                        under a DIR: heading
+  -S                    sort by size, largest first
+      --sort=WORD       sort by WORD instead of name: none (-U), size (-S),
+                        time (-t), version (-v) or extension (-X)
+  -t                    sort by modification time, newest first
+  -U                    do not sort; list entries in directory order
+  -v                    sort by natural version order of names
+  -w, --width=COLS      fit entries into COLS columns rather than the
+                        width of the terminal; 0 means no limit
+  -x                    list entries in columns, sorted across each row
+  -X                    sort by extension, the part of names after the
+                        last dot
   -1                    print one entry per line
   -h, --help            print this help message and exit
+
+Entries that sort the same are ordered by name.
 `
 )
 
@@ -51,104 +91,545 @@ func help() {
 	os.Exit(0)
 }
 
-func ls(file string, args arg) {
-	entries := make([]os.FileInfo, 0)
+// The state of a listing, carried from one FILE and directory to the
+// next.
+type lister struct {
+	args   arg
+	out    io.Writer
+	status int
 
-	// Determine if this is a file or directory, then call out
-	// to ReadDir if it's a directory. Otherwise, we're can just
-	// pass the file info on.
-	fi, err := os.Stat(file)
-	if err != nil {
-		panic(err)
-	} else if fi.IsDir() {
-		e, err := ioutil.ReadDir(file)
+	// Whether anything has been listed yet, so that sections can be
+	// separated by an empty line
+	listed bool
+
+	// Whether each directory is listed under a DIR: heading
+	headings bool
+
+	// The directories being listed by -R, to catch it looping back
+	// into one of them through a bind mount
+	active map[fileID]bool
+
+	// Owner and group names for the long format, read once up front
+	owners map[uint32]string
+	groups map[uint32]string
+}
+
+// Identifies a file by device and inode, which is the same for
+// each of the paths it can be reached by.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func newLister(args arg, out io.Writer) *lister {
+	l := &lister{args: args, out: out, active: make(map[fileID]bool)}
+	if args.long {
+		l.owners = readNames(passwd_file)
+		l.groups = readNames(group_file)
+	}
+	return l
+}
+
+// Report a problem listing file. Problems with the FILE operands
+// themselves are serious, anything below them is minor.
+func (l *lister) fail(message string, serious bool) {
+	fmt.Fprintf(os.Stderr, "ls: %s\n", message)
+	if serious {
+		l.status = 2
+	} else if l.status == 0 {
+		l.status = 1
+	}
+}
+
+// List files the way coreutils does: those that aren't directories
+// first, as a group, and then the entries of each directory in turn.
+// Each directory gets a DIR: heading when there's anything else listed.
+func (l *lister) ls(files []string) {
+	// The long format, and -d, describe symlinks themselves rather
+	// than what they point to
+	stat := os.Stat
+	if l.args.long || l.args.directory {
+		stat = os.Lstat
+	}
+
+	operands := make([]os.FileInfo, 0)
+	dirs := make([]os.FileInfo, 0)
+	for _, file := range files {
+		fi, err := stat(file)
 		if err != nil {
-			panic(err)
+			l.fail(fmt.Sprintf("cannot access '%s': %s", file, errmsg.Describe(err)), true)
+			continue
 		}
-		entries = e
+		// Operands are shown as they were given, not just by name
+		fi = namedInfo{fi, file}
+		if fi.IsDir() && !l.args.directory {
+			dirs = append(dirs, fi)
+		} else {
+			operands = append(operands, fi)
+		}
+	}
+
+	sortEntries(operands, &l.args)
+	sortEntries(dirs, &l.args)
+
+	if len(operands) > 0 {
+		if l.args.long {
+			// Columns are sized to fit the directories as well, as
+			// coreutils does
+			l.printLong("", operands, dirs, false)
+		} else {
+			printEntries(l.out, "", operands, &l.args)
+		}
+		l.listed = true
+	}
+	l.headings = len(files) > 1 || l.args.recursive
+	for _, fi := range dirs {
+		l.listDir(fi.Name(), fi, true)
+	}
+}
+
+// An os.FileInfo under another name.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (n namedInfo) Name() string { return n.name }
+
+// List the entries of the directory dir, described by fi, and then
+// with -R each of its subdirectories in turn.
+func (l *lister) listDir(dir string, fi os.FileInfo, operand bool) {
+	id, identified := identify(fi)
+	if identified && l.active[id] {
+		l.fail(fmt.Sprintf("%s: not listing already-listed directory", dir), true)
+		return
+	}
+
+	// Entries are read in directory order, for -U, and sorted later on
+	d, err := os.Open(dir)
+	if err != nil {
+		l.fail(fmt.Sprintf("cannot open directory '%s': %s", dir, errmsg.Describe(err)), operand)
+		return
+	}
+	entries, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		l.fail(fmt.Sprintf("reading directory '%s': %s", dir, errmsg.Describe(err)), operand)
+		return
+	}
+
+	entries = filterEntries(&entries, &l.args)
+	if l.args.all {
+		// Readdir leaves out . and .., so they're added here
+		for _, name := range []string{".", ".."} {
+			if fi, err := os.Lstat(joinPath(dir, name)); err == nil {
+				entries = append(entries, namedInfo{fi, name})
+			}
+		}
+	}
+	sortEntries(entries, &l.args)
+
+	if l.headings {
+		if l.listed {
+			fmt.Fprintln(l.out)
+		}
+		fmt.Fprintf(l.out, "%s:\n", dir)
+	}
+	if l.args.long {
+		l.printLong(dir, entries, nil, true)
 	} else {
-		entries = append(entries, fi)
+		printEntries(l.out, dir, entries, &l.args)
 	}
-	printEntries(&entries, &args)
+	l.listed = true
+
+	if !l.args.recursive {
+		return
+	}
+	if identified {
+		l.active[id] = true
+		defer delete(l.active, id)
+	}
+	for _, e := range entries {
+		// Entries are described by lstat, so symlinks to directories
+		// aren't followed
+		if e.IsDir() && e.Name() != "." && e.Name() != ".." {
+			l.listDir(joinPath(dir, e.Name()), e, false)
+		}
+	}
+}
+
+func identify(fi os.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
 }
 
-func printEntries(entries *[]os.FileInfo, args *arg) {
+// Join dir and name the way ls shows them, keeping the ./ in ./name.
+func joinPath(dir string, name string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+func printEntries(w io.Writer, dir string, entries []os.FileInfo, args *arg) {
 	var out bytes.Buffer
 
-	filtered_entries := filterEntries(entries, args)
+	if len(entries) == 0 {
+		return
+	}
 
 	// Determine the terminal width, useful for column and line
 	// wrapping calculations.
-	terminal_width, _, err := getTerminalSize()
-	if err != nil {
-		terminal_width = 78
+	terminal_width := args.line_width
+	if terminal_width == 0 {
+		var err error
+		terminal_width, _, err = getTerminalSize()
+		if err != nil {
+			terminal_width = 78
+		}
 	}
 
 	if args.one_per_line {
-		for _, e := range filtered_entries {
+		for _, e := range entries {
 			name := e.Name()
 			if args.quote_name {
 				name = fmt.Sprintf("\"%s\"", e.Name())
 			}
 			out.WriteString(fmt.Sprintf("%s\n", name))
 		}
-		fmt.Print(out.String())
+		fmt.Fprint(w, out.String())
 	} else if args.comma_separated {
-		for i, e := range filtered_entries {
+		for i, e := range entries {
 			var scratch bytes.Buffer
 			name := e.Name()
 			if args.quote_name {
 				name = fmt.Sprintf("\"%s\"", e.Name())
 			}
 			scratch.WriteString(name)
-			if i < len(filtered_entries)-1 {
+			if i < len(entries)-1 {
 				scratch.WriteString(", ")
 			}
 
 			// Finish out this line if we're going to hit the
 			// terminal width. The next entry will wrap to the
 			// next line.
-			if out.Len()+scratch.Len() >= terminal_width {
-				fmt.Println(out.String())
+			if terminal_width >= 0 && out.Len()+scratch.Len() >= terminal_width {
+				fmt.Fprintln(w, out.String())
 				out.Reset()
 			}
 			out.WriteString(scratch.String())
 		}
-		fmt.Println(out.String())
+		fmt.Fprintln(w, out.String())
 	} else {
-		longest_entry := 1
-		for _, e := range filtered_entries {
-			name := e.Name()
-			if args.quote_name {
-				name = fmt.Sprintf("\"%s\"", e.Name())
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = quoteName(e.Name(), args)
+		}
+		printColumns(w, names, terminal_width, !args.across)
+	}
+}
+
+// Print names in as few rows as fit within line_width columns, or
+// without limit if it's negative. Names are laid out down the columns,
+// or across the rows if !by_columns, and each column is as wide as its
+// widest name plus two spaces.
+func printColumns(w io.Writer, names []string, line_width int, by_columns bool) {
+	var out bytes.Buffer
+
+	widths := make([]int, len(names))
+	for i, name := range names {
+		widths[i] = width.String(name)
+	}
+
+	// Work out the widths of the columns for each possible number of
+	// them at once, starting from the narrowest a column can be, and
+	// use the most that still fit.
+	const min_column_width = 3
+	max_columns := len(names)
+	if line_width >= 0 && line_width/min_column_width < max_columns {
+		max_columns = line_width / min_column_width
+	}
+	if max_columns < 1 {
+		max_columns = 1
+	}
+
+	type layout struct {
+		fits    bool
+		length  int
+		columns []int
+	}
+	layouts := make([]layout, max_columns)
+	for i := range layouts {
+		layouts[i] = layout{fits: true, length: (i + 1) * min_column_width, columns: make([]int, i+1)}
+		for j := range layouts[i].columns {
+			layouts[i].columns[j] = min_column_width
+		}
+	}
+	for n, name_width := range widths {
+		for i := range layouts {
+			l := &layouts[i]
+			if !l.fits {
+				continue
+			}
+			column := n % (i + 1)
+			if by_columns {
+				column = n / ((len(names) + i) / (i + 1))
 			}
-			length := len(name)
-			if length > longest_entry {
-				longest_entry = length + 1
+			// The last column needs no space after it
+			length := name_width
+			if column != i {
+				length += 2
+			}
+			if l.columns[column] < length {
+				l.length += length - l.columns[column]
+				l.columns[column] = length
+				l.fits = line_width < 0 || l.length < line_width
 			}
 		}
+	}
+	columns := max_columns
+	for columns > 1 && !layouts[columns-1].fits {
+		columns--
+	}
+	column_widths := layouts[columns-1].columns
 
-		columns := int(terminal_width / longest_entry)
+	rows := (len(names) + columns - 1) / columns
+	for row := 0; row < rows; row++ {
+		pos, previous := 0, 0
+		for column := 0; column < columns; column++ {
+			n := row*columns + column
+			if by_columns {
+				n = column*rows + row
+			}
+			if n >= len(names) {
+				break
+			}
+			if column > 0 {
+				// Without a limit, coreutils pads with spaces alone
+				indent(&out, pos+widths[previous], pos+column_widths[column-1], line_width >= 0)
+				pos += column_widths[column-1]
+			}
+			out.WriteString(names[n])
+			previous = n
+		}
+		out.WriteString("\n")
+	}
+	fmt.Fprint(w, out.String())
+}
 
-		formatted_string := fmt.Sprintf("%%-%ds", longest_entry)
-		for i, e := range filtered_entries {
-			name := e.Name()
-			if args.quote_name {
-				name = fmt.Sprintf("\"%s\"", e.Name())
+// Pad from column from to column to, with tabs where they'd fit if tabs
+// is set, as coreutils does, assuming tab stops every 8 columns.
+func indent(out *bytes.Buffer, from int, to int, tabs bool) {
+	for from < to {
+		if tabs && to/8 > (from+1)/8 {
+			out.WriteByte('\t')
+			from += 8 - from%8
+		} else {
+			out.WriteByte(' ')
+			from++
+		}
+	}
+}
+
+func quoteName(name string, args *arg) string {
+	if args.quote_name {
+		return fmt.Sprintf("\"%s\"", name)
+	}
+	return name
+}
+
+// Print entries in the long listing format, one per line, with each
+// column sized to fit its widest value, in entries or in measured.
+func (l *lister) printLong(dir string, entries []os.FileInfo, measured []os.FileInfo, show_total bool) {
+	var out bytes.Buffer
+
+	args := &l.args
+	now := time.Now()
+
+	rows := make([][]string, 0)
+	widths := make([]int, 5)
+	major_width, minor_width := 0, 0
+	blocks := int64(0)
+	all := append(append([]os.FileInfo(nil), entries...), measured...)
+	for i, e := range all {
+		path := filepath.Join(dir, e.Name())
+		st, ok := e.Sys().(*syscall.Stat_t)
+		if !ok {
+			st = &syscall.Stat_t{}
+		}
+		if i < len(entries) {
+			blocks += int64(st.Blocks)
+		}
+
+		size := strconv.FormatInt(e.Size(), 10)
+		major, minor := "", ""
+		if e.Mode()&os.ModeDevice != 0 {
+			rdev := uint64(st.Rdev)
+			major = strconv.FormatUint((rdev>>8)&0xfff|(rdev>>32)&^0xfff, 10)
+			minor = strconv.FormatUint(rdev&0xff|(rdev>>12)&^0xff, 10)
+			size = ""
+			if len(major) > major_width {
+				major_width = len(major)
 			}
-			out.WriteString(fmt.Sprintf(formatted_string, name))
-			if i%columns == columns-1 {
-				out.WriteString("\n")
+			if len(minor) > minor_width {
+				minor_width = len(minor)
 			}
 		}
-		fmt.Println(out.String())
+
+		name := quoteName(e.Name(), args)
+		if e.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Readlink(path); err == nil {
+				name += " -> " + quoteName(target, args)
+			}
+		}
+
+		row := []string{
+			modeString(e, hasACL(path)),
+			strconv.FormatUint(uint64(st.Nlink), 10),
+			lookupName(l.owners, st.Uid),
+			lookupName(l.groups, st.Gid),
+			size,
+			modTime(e.ModTime(), now),
+			name,
+			major,
+			minor,
+		}
+		for j := range widths {
+			if len(row[j]) > widths[j] {
+				widths[j] = len(row[j])
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	// Device numbers take the place of the size, with the majors and
+	// the minors each lined up in a column of their own
+	if major_width > 0 && major_width+2+minor_width > widths[4] {
+		widths[4] = major_width + 2 + minor_width
+	}
+
+	if show_total {
+		// Blocks are counted in 512 bytes, but shown in kilobytes
+		out.WriteString(fmt.Sprintf("total %d\n", (blocks+1)/2))
 	}
+	for _, row := range rows[:len(entries)] {
+		if row[7] != "" {
+			row[4] = fmt.Sprintf("%*s, %*s", widths[4]-2-minor_width, row[7], minor_width, row[8])
+		}
+		out.WriteString(fmt.Sprintf("%-*s %*s %-*s %-*s %*s %s %s\n",
+			widths[0], row[0], widths[1], row[1], widths[2], row[2],
+			widths[3], row[3], widths[4], row[4], row[5], row[6]))
+	}
+	fmt.Fprint(l.out, out.String())
+}
+
+// Describe the file type and permissions of e the way ls -l does, e.g.
+// drwxr-sr-t. A trailing + marks a file with an access control list.
+func modeString(e os.FileInfo, acl bool) string {
+	mode := e.Mode()
+	buf := []byte("?rwxrwxrwx")
+
+	switch {
+	case mode.IsDir():
+		buf[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		buf[0] = 'l'
+	case mode&os.ModeNamedPipe != 0:
+		buf[0] = 'p'
+	case mode&os.ModeSocket != 0:
+		buf[0] = 's'
+	case mode&os.ModeCharDevice != 0:
+		buf[0] = 'c'
+	case mode&os.ModeDevice != 0:
+		buf[0] = 'b'
+	case mode.IsRegular():
+		buf[0] = '-'
+	}
+
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) == 0 {
+			buf[i+1] = '-'
+		}
+	}
+
+	// setuid, setgid and sticky replace the execute bits, in lower
+	// case when the execute bit is set as well
+	special := func(i int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if buf[i] == 'x' {
+			buf[i] = c
+		} else {
+			buf[i] = c - 'a' + 'A'
+		}
+	}
+	special(3, mode&os.ModeSetuid != 0, 's')
+	special(6, mode&os.ModeSetgid != 0, 's')
+	special(9, mode&os.ModeSticky != 0, 't')
+
+	if acl {
+		return string(buf) + "+"
+	}
+	return string(buf)
+}
+
+// Format t as ls -l does: the time of day for files modified in the
+// last six months, and the year for anything older or in the future.
+func modTime(t time.Time, now time.Time) string {
+	six_months := time.Duration(31556952/2) * time.Second
+	if t.After(now.Add(-six_months)) && !t.After(now) {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
+
+// Where owner and group names are looked up for the long format.
+var (
+	passwd_file = "/etc/passwd"
+	group_file  = "/etc/group"
+)
+
+// Read the id to name mapping from /etc/passwd or /etc/group, both of
+// which hold name:password:id:... records.
+func readNames(file string) map[uint32]string {
+	names := make(map[uint32]string)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return names
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		// The first entry for an id wins
+		if _, ok := names[uint32(id)]; !ok {
+			names[uint32(id)] = fields[0]
+		}
+	}
+	return names
+}
+
+// Look up the name for id, falling back to the number itself.
+func lookupName(names map[uint32]string, id uint32) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(id), 10)
 }
 
 func filterEntries(entries *[]os.FileInfo, args *arg) []os.FileInfo {
 	filtered_entries := make([]os.FileInfo, 0)
 	for _, e := range *entries {
-		if !args.almost_all && strings.HasPrefix(e.Name(), ".") {
+		if !args.all && !args.almost_all && strings.HasPrefix(e.Name(), ".") {
 			continue
 		}
 		if args.ignore_backups && strings.HasSuffix(e.Name(), "~") {
@@ -160,6 +641,207 @@ func filterEntries(entries *[]os.FileInfo, args *arg) []os.FileInfo {
 	return filtered_entries
 }
 
+// Sorts entries with a comparison function that returns a negative
+// number, zero or a positive number as a is before, the same as or after
+// b, like strings.Compare.
+type entrySorter struct {
+	entries []os.FileInfo
+	compare func(a, b os.FileInfo) int
+}
+
+func (s entrySorter) Len() int      { return len(s.entries) }
+func (s entrySorter) Swap(i, j int) { s.entries[i], s.entries[j] = s.entries[j], s.entries[i] }
+func (s entrySorter) Less(i, j int) bool {
+	return s.compare(s.entries[i], s.entries[j]) < 0
+}
+
+// Sort entries in place by the order args asks for. Entries that
+// compare the same are ordered by name, and -r reverses the whole
+// order, ties included.
+func sortEntries(entries []os.FileInfo, args *arg) {
+	var compare func(a, b os.FileInfo) int
+	switch args.sort_by {
+	case "none":
+		return
+	case "size":
+		compare = func(a, b os.FileInfo) int {
+			switch {
+			case a.Size() > b.Size():
+				return -1
+			case a.Size() < b.Size():
+				return 1
+			}
+			return strings.Compare(a.Name(), b.Name())
+		}
+	case "time":
+		compare = func(a, b os.FileInfo) int {
+			switch {
+			case a.ModTime().After(b.ModTime()):
+				return -1
+			case a.ModTime().Before(b.ModTime()):
+				return 1
+			}
+			return strings.Compare(a.Name(), b.Name())
+		}
+	case "extension":
+		compare = func(a, b os.FileInfo) int {
+			if c := strings.Compare(extension(a.Name()), extension(b.Name())); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Name(), b.Name())
+		}
+	case "version":
+		compare = func(a, b os.FileInfo) int {
+			if c := versionCompare(a.Name(), b.Name()); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Name(), b.Name())
+		}
+	default:
+		compare = func(a, b os.FileInfo) int {
+			return strings.Compare(a.Name(), b.Name())
+		}
+	}
+
+	if args.reverse {
+		forward := compare
+		compare = func(a, b os.FileInfo) int { return forward(b, a) }
+	}
+	sort.Stable(entrySorter{entries, compare})
+}
+
+// The extension of name is everything from its last dot, so a hidden
+// file with no other dot is all extension.
+func extension(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i:]
+	}
+	return ""
+}
+
+// Compare a and b in natural version order, so that file9 comes before
+// file10, following the rules of GNU filevercmp: . and .. come first,
+// then other hidden files, then everything else. Names are compared
+// first without their suffixes, like .tar.gz, and then with them.
+func versionCompare(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+
+	if a[0] == '.' {
+		if b[0] != '.' {
+			return -1
+		}
+		for _, special := range []string{".", ".."} {
+			if a == special {
+				return -1
+			}
+			if b == special {
+				return 1
+			}
+		}
+	} else if b[0] == '.' {
+		return 1
+	}
+
+	a_prefix, b_prefix := versionPrefix(a), versionPrefix(b)
+	c := compareVersions(a_prefix, b_prefix)
+	if c != 0 || (a_prefix == a && b_prefix == b) {
+		return c
+	}
+	return compareVersions(a, b)
+}
+
+// Trim name's suffix, a run of dots each followed by a letter or ~ and
+// then letters, digits and ~. The first byte is never part of a suffix.
+func versionPrefix(name string) string {
+	suffix_byte := func(c byte) bool {
+		return isAlpha(c) || isDigit(c) || c == '~'
+	}
+	prefix_length := 0
+	for i := 0; i < len(name); {
+		i++
+		prefix_length = i
+		for i+1 < len(name) && name[i] == '.' && (isAlpha(name[i+1]) || name[i+1] == '~') {
+			for i += 2; i < len(name) && suffix_byte(name[i]); i++ {
+			}
+		}
+	}
+	return name[:prefix_length]
+}
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// The weight of c outside a run of digits: ~ sorts before everything,
+// even the end of a name, and letters sort before other characters.
+func versionOrder(c byte) int {
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// Compare a and b as alternating runs of non-digits, weighed by
+// versionOrder, and runs of digits, compared as numbers.
+func compareVersions(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			a_c, b_c := 0, 0
+			if i < len(a) {
+				a_c = versionOrder(a[i])
+			}
+			if j < len(b) {
+				b_c = versionOrder(b[j])
+			}
+			if a_c != b_c {
+				return a_c - b_c
+			}
+			i++
+			j++
+		}
+
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+
+		// The longer number is the larger one; if they're the same
+		// length the first digit that differs decides
+		first_diff := 0
+		for i < len(a) && j < len(b) && isDigit(a[i]) && isDigit(b[j]) {
+			if first_diff == 0 {
+				first_diff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if first_diff != 0 {
+			return first_diff
+		}
+	}
+	return 0
+}
+
 // This bit thanks in part to:
 // - https://code.google.com/p/go/source/browse/ssh/terminal/util.go?repo=crypto#75 and
 // - http://stackoverflow.com/questions/16569433/get-terminal-size-in-go
@@ -187,7 +869,13 @@ func main() {
 			if os.Args[i] == "-h" || os.Args[i] == "--help" {
 				help()
 			}
+			if os.Args[i] == "-a" || os.Args[i] == "--all" {
+				args.all = true
+				args.almost_all = false
+				continue
+			}
 			if os.Args[i] == "-A" || os.Args[i] == "--almost-all" {
+				args.all = false
 				args.almost_all = true
 				continue
 			}
@@ -195,6 +883,20 @@ func main() {
 				args.ignore_backups = true
 				continue
 			}
+			if os.Args[i] == "-C" || os.Args[i] == "-x" {
+				args.across = os.Args[i] == "-x"
+				args.one_per_line = false
+				args.comma_separated = false
+				continue
+			}
+			if os.Args[i] == "-d" || os.Args[i] == "--directory" {
+				args.directory = true
+				continue
+			}
+			if os.Args[i] == "-l" {
+				args.long = true
+				continue
+			}
 			if os.Args[i] == "-m" {
 				args.comma_separated = true
 				continue
@@ -203,6 +905,70 @@ func main() {
 				args.quote_name = true
 				continue
 			}
+			if os.Args[i] == "-r" || os.Args[i] == "--reverse" {
+				args.reverse = true
+				continue
+			}
+			if os.Args[i] == "-R" || os.Args[i] == "--recursive" {
+				args.recursive = true
+				continue
+			}
+			if os.Args[i] == "-S" {
+				args.sort_by = "size"
+				continue
+			}
+			if os.Args[i] == "-t" {
+				args.sort_by = "time"
+				continue
+			}
+			if os.Args[i] == "-U" {
+				args.sort_by = "none"
+				continue
+			}
+			if os.Args[i] == "-v" {
+				args.sort_by = "version"
+				continue
+			}
+			if os.Args[i] == "-X" {
+				args.sort_by = "extension"
+				continue
+			}
+			if os.Args[i] == "--sort" || strings.HasPrefix(os.Args[i], "--sort=") {
+				word := strings.TrimPrefix(os.Args[i], "--sort=")
+				if os.Args[i] == "--sort" {
+					if i == len(os.Args)-1 {
+						usage("option requires value -- " + os.Args[i])
+					}
+					i++
+					word = os.Args[i]
+				}
+				switch word {
+				case "none", "size", "time", "version", "extension", "name":
+					args.sort_by = word
+				default:
+					usage("invalid argument for --sort -- " + word)
+				}
+				continue
+			}
+			if os.Args[i] == "-w" || os.Args[i] == "--width" || strings.HasPrefix(os.Args[i], "--width=") {
+				cols := strings.TrimPrefix(os.Args[i], "--width=")
+				if os.Args[i] == "-w" || os.Args[i] == "--width" {
+					if i == len(os.Args)-1 {
+						usage("option requires value -- " + os.Args[i])
+					}
+					i++
+					cols = os.Args[i]
+				}
+				line_width, err := strconv.Atoi(cols)
+				if err != nil || line_width < 0 {
+					usage("invalid line width -- " + cols)
+				}
+				if line_width == 0 {
+					line_width = -1
+				}
+				args.line_width = line_width
+				continue
+			}
 			if os.Args[i] == "-1" {
 				args.one_per_line = true
 				continue
@@ -221,10 +987,10 @@ func main() {
 	}
 
 	if len(args.file) == 0 {
-		ls("./", args)
-	} else {
-		for i := range args.file {
-			ls(args.file[i], args)
-		}
+		args.file = append(args.file, ".")
 	}
+
+	l := newLister(args, os.Stdout)
+	l.ls(args.file)
+	os.Exit(l.status)
 }
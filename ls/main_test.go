@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A stand-in for the os.FileInfo of a file that doesn't exist on disk.
+type fakeInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	stat    *syscall.Stat_t
+}
+
+func (f fakeInfo) Name() string       { return f.name }
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeInfo) ModTime() time.Time { return f.modTime }
+func (f fakeInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeInfo) Sys() interface{} {
+	if f.stat == nil {
+		return nil
+	}
+	return f.stat
+}
+
+func TestModeString(t *testing.T) {
+	modes := []struct {
+		mode os.FileMode
+		acl  bool
+		want string
+	}{
+		{0644, false, "-rw-r--r--"},
+		{os.ModeDir | 0755, false, "drwxr-xr-x"},
+		{os.ModeDir | os.ModeSticky | 0777, false, "drwxrwxrwt"},
+		{os.ModeDir | os.ModeSticky | 0770, false, "drwxrwx--T"},
+		{os.ModeSetuid | 0755, false, "-rwsr-xr-x"},
+		{os.ModeSetuid | os.ModeSetgid | 0644, false, "-rwSr-Sr--"},
+		{os.ModeSymlink | 0777, false, "lrwxrwxrwx"},
+		{os.ModeNamedPipe | 0600, false, "prw-------"},
+		{os.ModeSocket | 0755, false, "srwxr-xr-x"},
+		{os.ModeDevice | os.ModeCharDevice | 0666, false, "crw-rw-rw-"},
+		{os.ModeDevice | 0660, false, "brw-rw----"},
+		{0640, true, "-rw-r-----+"},
+	}
+	for _, m := range modes {
+		if got := modeString(fakeInfo{mode: m.mode}, m.acl); got != m.want {
+			t.Errorf("modeString(%v) = %s, want %s", m.mode, got, m.want)
+		}
+	}
+}
+
+func TestModTime(t *testing.T) {
+	now := time.Date(2014, 6, 15, 12, 0, 0, 0, time.UTC)
+	times := []struct {
+		t    time.Time
+		want string
+	}{
+		{now.Add(-time.Hour), "Jun 15 11:00"},
+		{time.Date(2014, 1, 2, 3, 4, 0, 0, time.UTC), "Jan  2 03:04"},
+		{time.Date(2013, 12, 1, 0, 0, 0, 0, time.UTC), "Dec  1  2013"},
+		{now.Add(time.Hour), "Jun 15  2014"},
+	}
+	for _, c := range times {
+		if got := modTime(c.t, now); got != c.want {
+			t.Errorf("modTime(%v) = %q, want %q", c.t, got, c.want)
+		}
+	}
+}
+
+func names(entries []os.FileInfo) []string {
+	n := make([]string, len(entries))
+	for i, e := range entries {
+		n[i] = e.Name()
+	}
+	return n
+}
+
+func TestSortEntries(t *testing.T) {
+	base := time.Date(2014, 6, 15, 12, 0, 0, 0, time.UTC)
+	entries := []os.FileInfo{
+		fakeInfo{name: "b.txt", size: 10, modTime: base},
+		fakeInfo{name: "a.go", size: 30, modTime: base.Add(-time.Hour)},
+		fakeInfo{name: "c", size: 10, modTime: base.Add(time.Hour)},
+		fakeInfo{name: "d.go", size: 20, modTime: base},
+	}
+
+	sorts := []struct {
+		sort_by string
+		reverse bool
+		want    string
+	}{
+		{"", false, "a.go b.txt c d.go"},
+		{"name", true, "d.go c b.txt a.go"},
+		{"none", false, "b.txt a.go c d.go"},
+		{"none", true, "b.txt a.go c d.go"},
+		{"size", false, "a.go d.go b.txt c"},
+		{"size", true, "c b.txt d.go a.go"},
+		{"time", false, "c b.txt d.go a.go"},
+		{"time", true, "a.go d.go b.txt c"},
+		{"extension", false, "c a.go d.go b.txt"},
+		{"extension", true, "b.txt d.go a.go c"},
+	}
+	for _, s := range sorts {
+		sorted := append([]os.FileInfo(nil), entries...)
+		sortEntries(sorted, &arg{sort_by: s.sort_by, reverse: s.reverse})
+		if got := strings.Join(names(sorted), " "); got != s.want {
+			t.Errorf("sort %q reverse %v = %s, want %s", s.sort_by, s.reverse, got, s.want)
+		}
+	}
+}
+
+func TestSortVersion(t *testing.T) {
+	// The order GNU ls -v lists these in
+	want := []string{
+		".", "..", ".b9", ".b10", ".hidden", "README", "a", "file1~",
+		"file01", "file1", "file1.txt", "file9", "file10", "foo-1.2",
+		"foo-1.2.tar.gz", "foo-1.2a", "foo-1.10.tar.gz", "noext", "x.c",
+		"x.go", "y.c",
+	}
+	entries := make([]os.FileInfo, len(want))
+	for i, name := range want {
+		entries[len(want)-1-i] = fakeInfo{name: name}
+	}
+	sortEntries(entries, &arg{sort_by: "version"})
+	if got := names(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("version sort = %v, want %v", got, want)
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	versions := []struct {
+		a, b string
+		want int
+	}{
+		{"a", "a", 0},
+		{"", "a", -1},
+		{"file9", "file10", -1},
+		{"file10", "file9", 1},
+		{"file01", "file1", 0},
+		{"1.2~rc1", "1.2", -1},
+		{"1.2", "1.2a", -1},
+		{"foo-1.2.tar.gz", "foo-1.10.tar.gz", -1},
+		{"foo.tar.gz", "foo", 1},
+		{".", "..", -1},
+		{"..", ".a", -1},
+		{".z", "a", -1},
+		{"a-b", "a+b", 1},
+	}
+	for _, v := range versions {
+		got := versionCompare(v.a, v.b)
+		if got < 0 {
+			got = -1
+		} else if got > 0 {
+			got = 1
+		}
+		if got != v.want {
+			t.Errorf("versionCompare(%q, %q) = %d, want %d", v.a, v.b, got, v.want)
+		}
+	}
+}
+
+// Build a directory tree for listing: names ending in / are directories,
+// and those with -> in them symlinks.
+func makeTree(t *testing.T, paths ...string) string {
+	dir, err := ioutil.TempDir("", "ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		path := filepath.Join(dir, p)
+		switch {
+		case strings.HasSuffix(p, "/"):
+			err = os.MkdirAll(path, 0755)
+		case strings.Contains(p, " -> "):
+			link := strings.SplitN(p, " -> ", 2)
+			err = os.Symlink(link[1], filepath.Join(dir, link[0]))
+		default:
+			err = ioutil.WriteFile(path, nil, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRecursive(t *testing.T) {
+	dir := makeTree(t, "a/b/", ".hidden/", "a/f", "a/b/g~", "a/b/h", "c/", "a/up -> ..")
+	defer os.RemoveAll(dir)
+
+	listings := []struct {
+		args arg
+		want string
+	}{
+		{
+			arg{one_per_line: true, recursive: true},
+			".:\na\nc\n\n./a:\nb\nf\nup\n\n./a/b:\ng~\nh\n\n./c:\n",
+		},
+		{
+			arg{one_per_line: true, recursive: true, almost_all: true, ignore_backups: true},
+			".:\n.hidden\na\nc\n\n./.hidden:\n\n./a:\nb\nf\nup\n\n./a/b:\nh\n\n./c:\n",
+		},
+		{
+			arg{one_per_line: true, recursive: true, reverse: true},
+			".:\nc\na\n\n./c:\n\n./a:\nup\nf\nb\n\n./a/b:\nh\ng~\n",
+		},
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	for _, c := range listings {
+		var out bytes.Buffer
+		l := newLister(c.args, &out)
+		l.ls([]string{"."})
+		if got := out.String(); got != c.want || l.status != 0 {
+			t.Errorf("ls %+v = %q, status %d; want %q", c.args, got, l.status, c.want)
+		}
+	}
+}
+
+func TestRecursiveLoop(t *testing.T) {
+	dir := makeTree(t, "a/b/")
+	defer os.RemoveAll(dir)
+
+	// A directory that's already being listed further up the tree,
+	// as if a/b were a bind mount of a, isn't listed again
+	var out bytes.Buffer
+	l := newLister(arg{one_per_line: true, recursive: true}, &out)
+	fi, err := os.Stat(filepath.Join(dir, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := identify(fi)
+	l.active[id] = true
+	l.ls([]string{filepath.Join(dir, "a")})
+
+	want := dir + "/a:\nb\n"
+	if out.String() != want || l.status != 2 {
+		t.Errorf("ls = %q, status %d; want %q, status 2", out.String(), l.status, want)
+	}
+}
+
+func TestUnreadableDirectory(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	dir := makeTree(t, "a/", "b/", "b/f")
+	defer os.RemoveAll(dir)
+	os.Chmod(filepath.Join(dir, "a"), 0)
+	defer os.Chmod(filepath.Join(dir, "a"), 0755)
+
+	var out bytes.Buffer
+	l := newLister(arg{one_per_line: true, recursive: true}, &out)
+	l.ls([]string{dir})
+
+	want := dir + ":\na\nb\n\n" + dir + "/b:\nf\n"
+	if out.String() != want || l.status != 1 {
+		t.Errorf("ls = %q, status %d; want %q, status 1", out.String(), l.status, want)
+	}
+}
+
+func TestOperands(t *testing.T) {
+	dir := makeTree(t, "a/", "a/.h", "a/f", "b/", "b/g", "x", "y")
+	defer os.RemoveAll(dir)
+
+	operands := []struct {
+		args   arg
+		files  []string
+		want   string
+		status int
+	}{
+		{arg{}, []string{"a"}, "f\n", 0},
+		{arg{}, []string{"y", "b", "x", "a"}, "x\ny\n\na:\nf\n\nb:\ng\n", 0},
+		{arg{}, []string{"b", "a"}, "a:\nf\n\nb:\ng\n", 0},
+		{arg{reverse: true}, []string{"a", "x", "b", "y"}, "y\nx\n\nb:\ng\n\na:\nf\n", 0},
+		{arg{sort_by: "none"}, []string{"y", "b", "x", "a"}, "y\nx\n\nb:\ng\n\na:\nf\n", 0},
+		{arg{}, []string{"missing", "a"}, "a:\nf\n", 2},
+		{arg{}, []string{"a/f", "./x"}, "./x\na/f\n", 0},
+		{arg{all: true}, []string{"a"}, ".\n..\n.h\nf\n", 0},
+		{arg{almost_all: true}, []string{"a"}, ".h\nf\n", 0},
+		{arg{directory: true}, []string{"b", "x", "a"}, "a\nb\nx\n", 0},
+		{arg{directory: true, recursive: true}, []string{"."}, ".\n", 0},
+		{arg{all: true, recursive: true}, []string{"b"}, "b:\n.\n..\ng\n", 0},
+	}
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	for _, c := range operands {
+		var out bytes.Buffer
+		c.args.one_per_line = true
+		l := newLister(c.args, &out)
+		l.ls(c.files)
+		if got := out.String(); got != c.want || l.status != c.status {
+			t.Errorf("ls %+v %v = %q, status %d; want %q, status %d",
+				c.args, c.files, got, l.status, c.want, c.status)
+		}
+	}
+}
+
+func TestPrintColumns(t *testing.T) {
+	names := []string{"alpha", "beta", "delta", "epsilon", "eta", "gamma", "theta", "zeta", "日本語"}
+
+	// The layouts GNU ls -C and -x give these names
+	layouts := []struct {
+		line_width int
+		by_columns bool
+		want       string
+	}{
+		{30, true, "alpha  epsilon\ttheta\nbeta   eta\tzeta\ndelta  gamma\t日本語\n"},
+		{30, false, "alpha\tbeta   delta  epsilon\neta\tgamma  theta  zeta\n日本語\n"},
+		{20, true, "alpha\t gamma\nbeta\t theta\ndelta\t zeta\nepsilon  日本語\neta\n"},
+		{-1, true, "alpha  beta  delta  epsilon  eta  gamma  theta  zeta  日本語\n"},
+		{5, true, strings.Join(names, "\n") + "\n"},
+		{0, false, strings.Join(names, "\n") + "\n"},
+	}
+	for _, l := range layouts {
+		var out bytes.Buffer
+		printColumns(&out, names, l.line_width, l.by_columns)
+		if got := out.String(); got != l.want {
+			t.Errorf("printColumns(%d, %v) =\n%s\nwant\n%s", l.line_width, l.by_columns, got, l.want)
+		}
+	}
+}
+
+func TestReadNames(t *testing.T) {
+	names := readNames(filepath.Join("testdata", "passwd"))
+	want := map[uint32]string{0: "root", 1000: "alice"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("readNames(passwd) = %v, want %v", names, want)
+	}
+	if got := lookupName(names, 4242); got != "4242" {
+		t.Errorf("lookupName(4242) = %q, want the number", got)
+	}
+	if got := readNames(filepath.Join("testdata", "missing")); len(got) != 0 {
+		t.Errorf("readNames(missing) = %v, want nothing", got)
+	}
+}
+
+func TestPrintLong(t *testing.T) {
+	passwd_file = filepath.Join("testdata", "passwd")
+	group_file = filepath.Join("testdata", "group")
+	defer func() {
+		passwd_file = "/etc/passwd"
+		group_file = "/etc/group"
+	}()
+
+	// Symlink targets are read from disk; everything else comes from
+	// the entries themselves
+	dir := makeTree(t, "link -> a.txt")
+	defer os.RemoveAll(dir)
+
+	old := time.Date(2013, 3, 4, 5, 6, 0, 0, time.Local)
+	entries := []os.FileInfo{
+		fakeInfo{name: "a.txt", size: 5, mode: 0644, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 1, Uid: 0, Gid: 0, Blocks: 8}},
+		fakeInfo{name: "big", size: 123456, mode: 0600, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 12, Uid: 1000, Gid: 100, Blocks: 248}},
+		fakeInfo{name: "link", size: 5, mode: os.ModeSymlink | 0777, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 1, Uid: 4242, Gid: 4343}},
+		fakeInfo{name: "sub", size: 4096, mode: os.ModeDir | 0755, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 2, Uid: 0, Gid: 100, Blocks: 9}},
+	}
+
+	listings := []struct {
+		args       arg
+		show_total bool
+		want       string
+	}{
+		{arg{long: true}, true, "" +
+			"total 133\n" +
+			"-rw-r--r--  1 root  wheel      5 Mar  4  2013 a.txt\n" +
+			"-rw------- 12 alice users 123456 Mar  4  2013 big\n" +
+			"lrwxrwxrwx  1 4242  4343       5 Mar  4  2013 link -> a.txt\n" +
+			"drwxr-xr-x  2 root  users   4096 Mar  4  2013 sub\n"},
+		{arg{long: true, quote_name: true}, false, "" +
+			"-rw-r--r--  1 root  wheel      5 Mar  4  2013 \"a.txt\"\n" +
+			"-rw------- 12 alice users 123456 Mar  4  2013 \"big\"\n" +
+			"lrwxrwxrwx  1 4242  4343       5 Mar  4  2013 \"link\" -> \"a.txt\"\n" +
+			"drwxr-xr-x  2 root  users   4096 Mar  4  2013 \"sub\"\n"},
+	}
+	for _, c := range listings {
+		var out bytes.Buffer
+		newLister(c.args, &out).printLong(dir, entries, nil, c.show_total)
+		if got := out.String(); got != c.want {
+			t.Errorf("printLong(%+v) =\n%s\nwant\n%s", c.args, got, c.want)
+		}
+	}
+
+	// Columns are sized to fit the measured entries too, without them
+	// being listed
+	var out bytes.Buffer
+	newLister(arg{long: true}, &out).printLong(dir, entries[:1], entries[1:], false)
+	want := "-rw-r--r--  1 root  wheel      5 Mar  4  2013 a.txt\n"
+	if out.String() != want {
+		t.Errorf("printLong with measured entries = %q, want %q", out.String(), want)
+	}
+
+	// Device majors and minors line up in columns of their own, as in
+	// GNU ls -l /dev/autofs /dev/loop0 /dev/null /etc/passwd
+	devices := []os.FileInfo{
+		fakeInfo{name: "autofs", mode: os.ModeDevice | os.ModeCharDevice | 0644, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 1, Rdev: 10<<8 | 235}},
+		fakeInfo{name: "loop0", mode: os.ModeDevice | 0600, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 1, Rdev: 7 << 8}},
+		fakeInfo{name: "null", mode: os.ModeDevice | os.ModeCharDevice | 0666, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 1, Rdev: 1<<8 | 3}},
+		fakeInfo{name: "passwd", size: 1146, mode: 0644, modTime: old,
+			stat: &syscall.Stat_t{Nlink: 1}},
+	}
+	out.Reset()
+	newLister(arg{long: true}, &out).printLong(dir, devices, nil, false)
+	want = "" +
+		"crw-r--r-- 1 root wheel 10, 235 Mar  4  2013 autofs\n" +
+		"brw------- 1 root wheel  7,   0 Mar  4  2013 loop0\n" +
+		"crw-rw-rw- 1 root wheel  1,   3 Mar  4  2013 null\n" +
+		"-rw-r--r-- 1 root wheel    1146 Mar  4  2013 passwd\n"
+	if out.String() != want {
+		t.Errorf("printLong of devices =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,329 @@
+// nl -- number lines of files
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/number"
+)
+
+const (
+	header = iota
+	body
+	footer
+)
+
+// Which lines of a section get numbered: all of them ('a'), only those
+// that aren't empty ('t'), none ('n'), or those matching pattern ('p').
+type style struct {
+	kind    byte
+	pattern *regexp.Regexp
+}
+
+func (s style) numbers(line []byte) bool {
+	switch s.kind {
+	case 'a':
+		return true
+	case 't':
+		return len(line) > 0
+	case 'p':
+		return s.pattern.Match(line)
+	}
+	return false
+}
+
+type arg struct {
+	styles      [3]style
+	delimiter   string
+	no_renumber bool
+	numbering   number.Numberer
+	file        []string
+}
+
+const (
+	usage_message string = "usage: nl [OPTION ...] [FILE ...]"
+	help_message  string = `Number the lines of FILE or STDIN and print them to STDOUT.
+When multiple files are passed in they are numbered as one.
+
+Input is split into logical pages, each made up of a header, a body and a
+footer. A line holding only \:\:\: starts a header, \:\: starts a body,
+and \: starts a footer; each of these lines is printed as an empty line
+and restarts the numbering. Input that comes before any of them is body.
+
+  -b, --body-numbering=STYLE      number body lines by STYLE; default t
+  -d, --section-delimiter=CC      use CC in place of \: to mark sections
+  -f, --footer-numbering=STYLE    number footer lines by STYLE; default n
+  -h, --header-numbering=STYLE    number header lines by STYLE; default n
+  -i, --line-increment=N          add N to the line number for each line;
+                                      default 1
+  -n, --number-format=FORMAT      format line numbers as FORMAT: ln for left
+                                      justified, rn for right justified, or
+                                      rz for right justified with leading
+                                      zeros; default rn
+  -p, --no-renumber               don't restart numbering at each section
+  -s, --number-separator=STRING   print STRING between the line number and
+                                      the line; default a tab character
+  -v, --starting-line-number=N    number the first line N; default 1
+  -w, --number-width=N            use N columns for line numbers; default 6
+      --help                      print this help message and exit
+
+STYLE is one of:
+  a         number all lines
+  t         number only lines that aren't empty
+  n         number no lines
+  pREGEXP   number only lines that match the regular expression REGEXP
+`
+)
+
+func usage(error string) {
+	fmt.Fprintf(os.Stderr, "nl: %s\n%s\n", error, usage_message)
+	os.Exit(1)
+}
+
+func help() {
+	fmt.Printf("%s\n%s", usage_message, help_message)
+	os.Exit(0)
+}
+
+func parse_args(args []string, i *int, s string, l string) (arg_v string) {
+	if args[*i] == s || args[*i] == l {
+		if len(args)-1 > *i {
+			*i++
+			return args[*i]
+		}
+		usage("option requires value -- " + args[*i])
+	}
+	if strings.HasPrefix(args[*i], l+"=") {
+		return strings.TrimPrefix(args[*i], l+"=")
+	}
+	if strings.HasPrefix(args[*i], s) {
+		return strings.TrimPrefix(args[*i], s)
+	}
+	return ""
+}
+
+func parse_style(s string) style {
+	switch {
+	case s == "a" || s == "t" || s == "n":
+		return style{kind: s[0]}
+	case strings.HasPrefix(s, "p"):
+		pattern, err := regexp.Compile(s[1:])
+		if err != nil {
+			usage("invalid regular expression -- " + s[1:])
+		}
+		return style{kind: 'p', pattern: pattern}
+	}
+	usage("invalid numbering style -- " + s)
+	return style{}
+}
+
+// The state of numbering, carried from one FILE to the next so that
+// they're numbered as one.
+type numberer struct {
+	args    arg
+	number  number.Numberer
+	section int
+}
+
+func newNumberer(args arg) *numberer {
+	return &numberer{args: args, number: args.numbering, section: body}
+}
+
+func nl(file io.Reader, out io.Writer, n *numberer) error {
+	if file == nil {
+		file = os.Stdin
+	}
+
+	r := bufio.NewReader(file)
+	w := bufio.NewWriter(out)
+
+	delimiter := []byte(n.args.delimiter)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			text := bytes.TrimSuffix(line, []byte("\n"))
+
+			// Section delimiters are replaced with an empty line
+			section := -1
+			if len(delimiter) > 0 && len(text)%len(delimiter) == 0 &&
+				bytes.Equal(text, bytes.Repeat(delimiter, len(text)/len(delimiter))) {
+				switch len(text) / len(delimiter) {
+				case 3:
+					section = header
+				case 2:
+					section = body
+				case 1:
+					section = footer
+				}
+			}
+
+			if section != -1 {
+				n.section = section
+				if !n.args.no_renumber {
+					n.number.Next = n.args.numbering.Next
+				}
+			} else {
+				if n.args.styles[n.section].numbers(text) {
+					n.number.Write(w)
+				} else {
+					n.number.Blank(w)
+				}
+				w.Write(text)
+			}
+
+			// The last line is always ended, as in POSIX nl. Stop
+			// at the first write error rather than reading on.
+			if werr := w.WriteByte('\n'); werr != nil {
+				return werr
+			}
+		}
+
+		if err == io.EOF {
+			return w.Flush()
+		} else if err != nil {
+			w.Flush()
+			return err
+		}
+	}
+}
+
+func main() {
+	args := arg{
+		styles:    [3]style{{kind: 'n'}, {kind: 't'}, {kind: 'n'}},
+		delimiter: `\:`,
+		numbering: number.Default,
+	}
+	reached_files := false
+	for i := 1; i < len(os.Args); i++ {
+		if reached_files == false {
+			if os.Args[i] == "--help" {
+				help()
+			}
+			if arg_v := parse_args(os.Args, &i, "-b", "--body-numbering"); arg_v != "" {
+				args.styles[body] = parse_style(arg_v)
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-h", "--header-numbering"); arg_v != "" {
+				args.styles[header] = parse_style(arg_v)
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-f", "--footer-numbering"); arg_v != "" {
+				args.styles[footer] = parse_style(arg_v)
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-d", "--section-delimiter"); arg_v != "" {
+				// A lone character is followed by the usual ':'
+				if len(arg_v) == 1 {
+					arg_v += ":"
+				}
+				args.delimiter = arg_v
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-i", "--line-increment"); arg_v != "" {
+				increment, err := strconv.ParseInt(arg_v, 10, 64)
+				if err != nil {
+					usage("invalid line number increment -- " + arg_v)
+				}
+				args.numbering.Increment = increment
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-n", "--number-format"); arg_v != "" {
+				if !number.ValidFormat(arg_v) {
+					usage("invalid line number format -- " + arg_v)
+				}
+				args.numbering.Format = arg_v
+				continue
+			}
+			if os.Args[i] == "-p" || os.Args[i] == "--no-renumber" {
+				args.no_renumber = true
+				continue
+			}
+			// The separator may legitimately be empty, which
+			// parse_args can't tell apart from a missing option
+			if os.Args[i] == "-s" || os.Args[i] == "--number-separator" {
+				if i == len(os.Args)-1 {
+					usage("option requires value -- " + os.Args[i])
+				}
+				i++
+				args.numbering.Separator = os.Args[i]
+				continue
+			}
+			if strings.HasPrefix(os.Args[i], "--number-separator=") {
+				args.numbering.Separator = strings.TrimPrefix(os.Args[i], "--number-separator=")
+				continue
+			}
+			if strings.HasPrefix(os.Args[i], "-s") {
+				args.numbering.Separator = strings.TrimPrefix(os.Args[i], "-s")
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-v", "--starting-line-number"); arg_v != "" {
+				start, err := strconv.ParseInt(arg_v, 10, 64)
+				if err != nil {
+					usage("invalid starting line number -- " + arg_v)
+				}
+				args.numbering.Next = start
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "-w", "--number-width"); arg_v != "" {
+				width, err := strconv.Atoi(arg_v)
+				if err != nil || width < 1 {
+					usage("invalid line number field width -- " + arg_v)
+				}
+				args.numbering.Width = width
+				continue
+			}
+			if os.Args[i] == "--" {
+				reached_files = true
+				continue
+			}
+		}
+		arg_v := os.Args[i]
+		reached_files = true
+		args.file = append(args.file, arg_v)
+	}
+
+	if len(args.file) == 0 {
+		args.file = append(args.file, "-")
+	}
+
+	// Numbering carries on past a FILE that can't be read
+	status := 0
+	n := newNumberer(args)
+	for i := range args.file {
+		file := os.Stdin
+		if args.file[i] != "-" {
+			var err error
+			file, err = os.Open(args.file[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "nl: %s: %s\n", args.file[i], errmsg.Describe(err))
+				status = 1
+				continue
+			}
+		}
+
+		if err := nl(file, os.Stdout, n); err != nil {
+			fmt.Fprintf(os.Stderr, "nl: %s: %s\n", args.file[i], errmsg.Describe(err))
+			status = 1
+		}
+
+		if file != os.Stdin {
+			file.Close()
+		}
+	}
+	os.Exit(status)
+}
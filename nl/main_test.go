@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/trevorparker/goutils/internal/number"
+)
+
+func TestSections(t *testing.T) {
+	in := "pre\n\\:\\:\\:\nhead\n\\:\\:\nbody\n\nmore\n\\:\nfoot\n"
+	defaults := arg{
+		styles:    [3]style{{kind: 'n'}, {kind: 't'}, {kind: 'n'}},
+		delimiter: `\:`,
+		numbering: number.Default,
+	}
+
+	all := defaults
+	all.styles = [3]style{{kind: 'a'}, {kind: 'a'}, {kind: 'a'}}
+
+	no_renumber := all
+	no_renumber.no_renumber = true
+
+	matching := defaults
+	matching.styles[body] = style{kind: 'p', pattern: regexp.MustCompile("^m")}
+
+	cases := []struct {
+		args arg
+		out  string
+	}{
+		{defaults, "     1\tpre\n\n       head\n\n     1\tbody\n       \n     2\tmore\n\n       foot\n"},
+		{all, "     1\tpre\n\n     1\thead\n\n     1\tbody\n     2\t\n     3\tmore\n\n     1\tfoot\n"},
+		{no_renumber, "     1\tpre\n\n     2\thead\n\n     3\tbody\n     4\t\n     5\tmore\n\n     6\tfoot\n"},
+		{matching, "       pre\n\n       head\n\n       body\n       \n     1\tmore\n\n       foot\n"},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if err := nl(strings.NewReader(in), &out, newNumberer(c.args)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.out {
+			t.Errorf("nl with styles %v = %q, want %q", c.args.styles, out.String(), c.out)
+		}
+	}
+}
+
+// Numbering and the current section carry on into the next file.
+func TestAcrossFiles(t *testing.T) {
+	args := arg{
+		styles:    [3]style{{kind: 'a'}, {kind: 't'}, {kind: 'n'}},
+		delimiter: `\:`,
+		numbering: number.Default,
+	}
+	n := newNumberer(args)
+
+	var out bytes.Buffer
+	nl(strings.NewReader("a\n\\:\\:\\:\n"), &out, n)
+	nl(strings.NewReader("h\nno newline"), &out, n)
+	nl(strings.NewReader("next"), &out, n)
+	if want := "     1\ta\n\n     1\th\n     2\tno newline\n     3\tnext\n"; out.String() != want {
+		t.Errorf("nl = %q, want %q", out.String(), want)
+	}
+}
+
+// A writer that fails the way a full disk does.
+type fullWriter struct{}
+
+func (fullWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestWriteError(t *testing.T) {
+	n := newNumberer(arg{styles: [3]style{{kind: 'n'}, {kind: 't'}, {kind: 'n'}}, numbering: number.Default})
+	for _, in := range []string{"a\n", strings.Repeat("line\n", 10000)} {
+		if err := nl(strings.NewReader(in), fullWriter{}, n); err == nil {
+			t.Errorf("nl of %d bytes to a full writer returned no error", len(in))
+		}
+	}
+}
@@ -0,0 +1,591 @@
+// tail -- print the last part of files
+// Part of goutils (https://github.com/trevorparker/goutils)
+//
+// Copyright (c) 2014 Trevor Parker <trevor@trevorparker.com>
+// All rights reserved
+//
+// Distributed under the terms of the Modified BSD License (see LICENSE)
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/size"
+)
+
+const (
+	follow_none = iota
+	follow_descriptor
+	follow_name
+)
+
+type arg struct {
+	count          int64
+	bytes          bool
+	from_start     bool
+	delimiter      byte
+	follow         int
+	retry          bool
+	sleep_interval time.Duration
+	quiet          bool
+	verbose        bool
+	file           []string
+}
+
+const (
+	usage_message string = "usage: tail [OPTION ...] [FILE ...]"
+	help_message  string = `Print the end matter of FILE or STDIN.
+A header describing the file name is prefixed when multiple files are passed
+in. When no FILE is provided, read from STDIN.
+
+  -c, --bytes=[+]N          print the last N bytes of FILE or STDIN;
+                                with a leading '+', print starting with
+                                byte N
+  -f, --follow[={name|descriptor}]
+                            print data appended to FILE as it grows;
+                                -f and --follow mean --follow=descriptor
+  -F                        same as --follow=name --retry
+  -n, --lines=[+]N          print the last N lines of FILE or STDIN;
+                                with a leading '+', print starting with
+                                line N; default 10
+  -q, --quiet, --silent     don't print file name headers
+      --retry               keep trying to open FILE if it is inaccessible
+  -s, --sleep-interval=N    with -f, check FILE for changes every N
+                                seconds; default 1
+  -v, --verbose             always print file name headers
+  -z, --zero-terminated     line delimiter is NUL, not newline
+  -h, --help                print this help message and exit
+
+N may have a multiplier suffix: b 512, kB 1000, K 1024, MB 1000*1000,
+M 1024*1024, GB 1000*1000*1000, G 1024*1024*1024, and so on for T, P and
+E. Binary prefixes may also be written as KiB, MiB, and so on.
+
+With --follow=descriptor, tail keeps reading the file it opened even after
+it is renamed or removed. With --follow=name, tail watches the file name and
+reopens it when it is replaced, e.g. by log rotation.
+`
+)
+
+func usage(error string) {
+	fmt.Fprintf(os.Stderr, "tail: %s\n%s\n", error, usage_message)
+	os.Exit(1)
+}
+
+func help() {
+	fmt.Printf("%s\n%s", usage_message, help_message)
+	os.Exit(0)
+}
+
+func parse_args(args []string, i *int, s string, l string) (arg_v string) {
+	if args[*i] == s || args[*i] == l {
+		if len(args)-1 > *i {
+			*i++
+			return args[*i]
+		}
+		usage("option requires value -- " + args[*i])
+	}
+	if strings.HasPrefix(args[*i], l+"=") {
+		return strings.TrimPrefix(args[*i], l+"=")
+	}
+	if strings.HasPrefix(args[*i], s) {
+		return strings.TrimPrefix(args[*i], s)
+	}
+	return ""
+}
+
+// Parse a count passed to -c or -n, with an optional multiplier suffix.
+// A leading '+' counts from the start of the input rather than the end.
+func parse_count(s string) (count int64, from_start bool, err error) {
+	if strings.HasPrefix(s, "+") {
+		from_start = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	count, err = size.Parse(s)
+	return count, from_start, err
+}
+
+func tail(file *os.File, args arg, w io.Writer) error {
+	if file == nil {
+		file = os.Stdin
+	}
+
+	// Regular files can be read from the end without touching the
+	// data before it; anything else has to be streamed through.
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	seekable := fi.Mode().IsRegular()
+
+	if args.from_start {
+		// +N is 1-based: +1 prints everything
+		skip := args.count - 1
+		if skip < 0 {
+			skip = 0
+		}
+		if args.bytes && seekable {
+			if _, err := file.Seek(skip, io.SeekCurrent); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, file)
+			return err
+		} else if args.bytes {
+			if _, err := io.CopyN(ioutil.Discard, file, skip); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			_, err := io.Copy(w, file)
+			return err
+		}
+
+		r := bufio.NewReader(file)
+		for l := int64(0); l < skip; l++ {
+			_, err := r.ReadBytes(args.delimiter)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	if seekable {
+		start, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		end := fi.Size()
+		offset := end - args.count
+		if !args.bytes {
+			offset, err = tailLinesOffset(file, start, end, args.count, args.delimiter)
+			if err != nil {
+				return err
+			}
+		}
+		if offset < start {
+			offset = start
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		return err
+	}
+
+	if args.bytes {
+		return tailBytes(file, w, args.count)
+	}
+	return tailLines(bufio.NewReader(file), w, args.count, args.delimiter)
+}
+
+// Find the offset of the first of the last n lines of r, reading
+// backwards in blocks from end towards start. A final line without a
+// trailing delimiter still counts as a line.
+func tailLinesOffset(r io.ReaderAt, start int64, end int64, n int64, delim byte) (int64, error) {
+	if n == 0 {
+		return end, nil
+	}
+
+	buf := make([]byte, 32*1024)
+	pos := end
+	skip_last := true
+	for pos > start {
+		size := int64(len(buf))
+		if pos-start < size {
+			size = pos - start
+		}
+		pos -= size
+		if _, err := r.ReadAt(buf[:size], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := size - 1; i >= 0; i-- {
+			if buf[i] != delim {
+				skip_last = false
+				continue
+			}
+			// The delimiter ending the final line doesn't
+			// start a new one
+			if skip_last {
+				skip_last = false
+				continue
+			}
+			n--
+			if n == 0 {
+				return pos + i + 1, nil
+			}
+		}
+	}
+	return start, nil
+}
+
+// Write the last n bytes of r. Only the trailing n bytes are held back,
+// so arbitrarily large streams never sit in memory. Older bytes are
+// dropped in blocks once at least n more have come in, so moving the
+// last n to the front of the buffer is paid for by the bytes dropped,
+// and the buffer never grows past 2n plus one read.
+func tailBytes(r io.Reader, w io.Writer, n int64) error {
+	buf := make([]byte, 32*1024)
+	held := make([]byte, 0)
+	for {
+		c, err := r.Read(buf)
+		held = append(held, buf[:c]...)
+		if over := int64(len(held)) - n; over > 0 && over >= n {
+			held = held[:copy(held, held[over:])]
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if over := int64(len(held)) - n; over > 0 {
+		held = held[over:]
+	}
+	_, err := w.Write(held)
+	return err
+}
+
+// Write the last n lines of r, each ending with delim. The lines are
+// kept in a ring that grows to at most n entries.
+func tailLines(r *bufio.Reader, w io.Writer, n int64, delim byte) error {
+	ring := make([][]byte, 0)
+	pos := 0
+	for {
+		l, err := r.ReadBytes(delim)
+		if len(l) > 0 && n > 0 {
+			if int64(len(ring)) < n {
+				ring = append(ring, l)
+			} else {
+				ring[pos] = l
+				pos = (pos + 1) % len(ring)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	for i := range ring {
+		if _, err := w.Write(ring[(pos+i)%len(ring)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A file being watched with -f or -F.
+type followed struct {
+	name     string
+	file     *os.File
+	info     os.FileInfo
+	offset   int64
+	reported bool
+}
+
+type follower struct {
+	files []*followed
+	args  arg
+	w     *bufio.Writer
+	last  *followed
+}
+
+// Print a header when output switches to a different file than the one
+// that last produced data.
+func (f *follower) header(fl *followed) {
+	if f.last == fl {
+		return
+	}
+	if len(f.files) > 1 && !f.args.quiet || f.args.verbose {
+		fmt.Fprintf(f.w, "\n==> %s <==\n", fl.name)
+	}
+	f.last = fl
+}
+
+// Copy anything appended to fl since the last poll, starting over if the
+// file has been truncated underneath us.
+func (f *follower) drain(fl *followed) error {
+	fi, err := fl.file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Mode().IsRegular() && fi.Size() < fl.offset {
+		fmt.Fprintf(os.Stderr, "tail: %s: file truncated\n", fl.name)
+		if fl.offset, err = fl.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if fi.Mode().IsRegular() && fi.Size() == fl.offset {
+		return nil
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := fl.file.Read(buf)
+		if n > 0 {
+			f.header(fl)
+			f.w.Write(buf[:n])
+			fl.offset += int64(n)
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF || n == 0 {
+			return nil
+		}
+	}
+}
+
+// Only regular files, FIFOs and character devices can grow, so
+// anything else (a directory, say) is not worth watching.
+func followable(mode os.FileMode) bool {
+	return mode.IsRegular() || mode&(os.ModeNamedPipe|os.ModeCharDevice) != 0
+}
+
+// With --follow=name, check whether the name now refers to a different
+// file than the one we have open, and switch over to it if so.
+func (f *follower) reopen(fl *followed) {
+	fi, err := os.Stat(fl.name)
+	if err != nil {
+		if !fl.reported {
+			fmt.Fprintf(os.Stderr, "tail: %s: has become inaccessible: %s\n", fl.name, errmsg.Describe(err))
+			fl.reported = true
+		}
+		if !f.args.retry && fl.file != nil {
+			fl.file.Close()
+			fl.file = nil
+		}
+		return
+	}
+	if fl.file != nil && os.SameFile(fi, fl.info) {
+		return
+	}
+
+	file, err := os.Open(fl.name)
+	if err != nil {
+		if !fl.reported {
+			fmt.Fprintf(os.Stderr, "tail: %s: %s\n", fl.name, errmsg.Describe(err))
+			fl.reported = true
+		}
+		return
+	}
+	if fl.file != nil {
+		fmt.Fprintf(os.Stderr, "tail: %s: has been replaced; following new file\n", fl.name)
+		fl.file.Close()
+	} else {
+		fmt.Fprintf(os.Stderr, "tail: %s: has appeared; following new file\n", fl.name)
+	}
+	fl.file = file
+	fl.info, _ = file.Stat()
+	fl.offset = 0
+	fl.reported = false
+}
+
+// Check every file once for new data. Returns false once there is
+// nothing left that could ever produce more output.
+func (f *follower) poll() bool {
+	active := false
+	for _, fl := range f.files {
+		if fl.file != nil {
+			if err := f.drain(fl); err != nil {
+				fmt.Fprintf(os.Stderr, "tail: %s: %s\n", fl.name, errmsg.Describe(err))
+			}
+		}
+		if f.args.follow == follow_name {
+			f.reopen(fl)
+			// Pick up whatever the new file already holds
+			if fl.file != nil && fl.offset == 0 {
+				f.drain(fl)
+			}
+		}
+		if fl.file != nil || f.args.retry {
+			active = true
+		}
+	}
+	f.w.Flush()
+	return active
+}
+
+func (f *follower) run() {
+	for f.poll() {
+		time.Sleep(f.args.sleep_interval)
+	}
+	fmt.Fprintln(os.Stderr, "tail: no files remaining")
+	os.Exit(1)
+}
+
+func main() {
+	args := arg{10, false, false, '\n', follow_none, false, time.Second, false, false, []string{}}
+	reached_files := false
+	for i := 1; i < len(os.Args); i++ {
+		if reached_files == false {
+			var err error
+			if os.Args[i] == "-h" || os.Args[i] == "--help" {
+				help()
+			}
+			if os.Args[i] == "-f" || os.Args[i] == "--follow" || os.Args[i] == "--follow=descriptor" {
+				args.follow = follow_descriptor
+				continue
+			}
+			if os.Args[i] == "--follow=name" {
+				args.follow = follow_name
+				continue
+			}
+			if os.Args[i] == "-F" {
+				args.follow = follow_name
+				args.retry = true
+				continue
+			}
+			if os.Args[i] == "--retry" {
+				args.retry = true
+				continue
+			}
+			arg_v := parse_args(os.Args, &i, "-n", "--lines")
+			if arg_v != "" {
+				args.count, args.from_start, err = parse_count(arg_v)
+				if err != nil {
+					usage(fmt.Sprintf("invalid number of lines (%s) -- %s", err, arg_v))
+				}
+				args.bytes = false
+				continue
+			}
+			arg_v = parse_args(os.Args, &i, "-c", "--bytes")
+			if arg_v != "" {
+				args.count, args.from_start, err = parse_count(arg_v)
+				if err != nil {
+					usage(fmt.Sprintf("invalid number of bytes (%s) -- %s", err, arg_v))
+				}
+				args.bytes = true
+				continue
+			}
+			arg_v = parse_args(os.Args, &i, "-s", "--sleep-interval")
+			if arg_v != "" {
+				seconds, err := strconv.ParseFloat(arg_v, 64)
+				if err != nil || seconds < 0 {
+					usage("invalid number of seconds -- " + arg_v)
+				}
+				args.sleep_interval = time.Duration(seconds * float64(time.Second))
+				continue
+			}
+			if os.Args[i] == "-q" || os.Args[i] == "--quiet" || os.Args[i] == "--silent" {
+				args.quiet = true
+				continue
+			}
+			if os.Args[i] == "-v" || os.Args[i] == "--verbose" {
+				args.verbose = true
+				continue
+			}
+			if os.Args[i] == "-z" || os.Args[i] == "--zero-terminated" {
+				args.delimiter = 0
+				continue
+			}
+			if os.Args[i] == "--" {
+				reached_files = true
+				continue
+			}
+			if len(os.Args[i]) > 1 && strings.HasPrefix(os.Args[i], "-") && !strings.HasPrefix(os.Args[i], "--") {
+				args.count, err = strconv.ParseInt(os.Args[i][1:], 10, 64)
+				if err != nil || args.count < 0 {
+					usage("illegal option " + os.Args[i])
+				}
+				args.from_start = false
+				args.bytes = false
+				continue
+			}
+		}
+		arg_v := os.Args[i]
+		reached_files = true
+		args.file = append(args.file, arg_v)
+	}
+
+	if len(args.file) == 0 {
+		args.file = append(args.file, "-")
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	f := follower{args: args, w: w}
+
+	// Files that can't be opened are still followed with --retry, but
+	// count against the exit status like any other failure
+	status := 0
+	printed_header := false
+	for i := range args.file {
+		var file *os.File
+		name := args.file[i]
+		if name == "-" {
+			name = "standard input"
+			file = os.Stdin
+		} else {
+			var err error
+			file, err = os.Open(args.file[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "tail: %s: %s\n", args.file[i], errmsg.Describe(err))
+				status = 1
+				if args.follow == follow_name && args.retry {
+					f.files = append(f.files, &followed{name: args.file[i], reported: true})
+				}
+				continue
+			}
+		}
+
+		// Print headers for the filenames if we are handling
+		// multiple files
+		if len(args.file) > 1 && !args.quiet || args.verbose {
+			if printed_header {
+				fmt.Fprintf(w, "\n==> %s <==\n", name)
+			} else {
+				fmt.Fprintf(w, "==> %s <==\n", name)
+			}
+			printed_header = true
+		}
+
+		if err := tail(file, args, w); err != nil {
+			fmt.Fprintf(os.Stderr, "tail: %s: %s\n", args.file[i], errmsg.Describe(err))
+			status = 1
+		}
+		w.Flush()
+
+		// Pipes can't grow once they've hit EOF, so there is
+		// nothing to follow on them. Standard input has no name
+		// to watch either.
+		fi, err := file.Stat()
+		giving_up := args.follow != follow_none && err == nil && !followable(fi.Mode())
+		if giving_up {
+			fmt.Fprintf(os.Stderr, "tail: %s: cannot follow end of this type of file; giving up on this name\n", name)
+			status = 1
+		}
+		if args.follow == follow_none || err != nil || giving_up || fi.Mode()&os.ModeNamedPipe != 0 ||
+			args.follow == follow_name && file == os.Stdin {
+			if file != os.Stdin {
+				file.Close()
+			}
+			continue
+		}
+		offset, _ := file.Seek(0, io.SeekCurrent)
+		fl := &followed{name: name, file: file, info: fi, offset: offset}
+		f.files = append(f.files, fl)
+		f.last = fl
+	}
+
+	if len(f.files) > 0 {
+		f.run()
+	} else if args.follow != follow_none && status != 0 {
+		fmt.Fprintln(os.Stderr, "tail: no files remaining")
+	}
+	os.Exit(status)
+}
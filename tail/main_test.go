@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/trevorparker/goutils/internal/size"
+)
+
+func TestParseCount(t *testing.T) {
+	counts := []struct {
+		in         string
+		count      int64
+		from_start bool
+		err        error
+	}{
+		{"10", 10, false, nil},
+		{"-5", 5, false, nil},
+		{"+5", 5, true, nil},
+		{"+1K", 1024, true, nil},
+		{"8E", 0, false, size.ErrTooLarge},
+		{"10X", 0, false, size.ErrInvalidSuffix},
+		{"+", 0, true, size.ErrInvalid},
+		{"+-1", 0, true, size.ErrInvalid},
+	}
+	for _, c := range counts {
+		count, from_start, err := parse_count(c.in)
+		if err != c.err {
+			t.Errorf("parse_count(%q) error = %v, want %v", c.in, err, c.err)
+			continue
+		}
+		if err == nil && (count != c.count || from_start != c.from_start) {
+			t.Errorf("parse_count(%q) = %d, %v, want %d, %v",
+				c.in, count, from_start, c.count, c.from_start)
+		}
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	cases := []struct {
+		in    string
+		n     int64
+		delim byte
+		out   string
+	}{
+		{"1\n2\n3\n4\n", 2, '\n', "3\n4\n"},
+		{"1\n2\n3\n4", 2, '\n', "3\n4"},
+		{"1\n2\n", 5, '\n', "1\n2\n"},
+		{"1\n2\n", 0, '\n', ""},
+		{"\n\n\n", 2, '\n', "\n\n"},
+		{"", 3, '\n', ""},
+		{"a\x00b\x00c\x00", 1, 0, "c\x00"},
+	}
+	for _, c := range cases {
+		// Streamed input
+		var out bytes.Buffer
+		r := bufio.NewReader(strings.NewReader(c.in))
+		if err := tailLines(r, &out, c.n, c.delim); err != nil {
+			t.Errorf("tailLines(%q, %d) error: %v", c.in, c.n, err)
+		}
+		if out.String() != c.out {
+			t.Errorf("tailLines(%q, %d) = %q, want %q", c.in, c.n, out.String(), c.out)
+		}
+
+		// Seekable input must agree with the streamed result
+		offset, err := tailLinesOffset(strings.NewReader(c.in), 0, int64(len(c.in)), c.n, c.delim)
+		if err != nil {
+			t.Errorf("tailLinesOffset(%q, %d) error: %v", c.in, c.n, err)
+		}
+		if c.in[offset:] != c.out {
+			t.Errorf("tailLinesOffset(%q, %d) = %d, want the start of %q", c.in, c.n, offset, c.out)
+		}
+	}
+}
+
+func TestTailLinesOffsetAcrossBlocks(t *testing.T) {
+	line := strings.Repeat("x", 20000) + "\n"
+	in := strings.Repeat(line, 5)
+	offset, err := tailLinesOffset(strings.NewReader(in), 0, int64(len(in)), 3, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(2 * len(line)); offset != want {
+		t.Errorf("tailLinesOffset = %d, want %d", offset, want)
+	}
+}
+
+func TestTailBytes(t *testing.T) {
+	long := strings.Repeat("0123456789", 10000)
+	cases := []struct {
+		in  string
+		n   int64
+		out string
+	}{
+		{"abcdef", 2, "ef"},
+		{"abcdef", 0, ""},
+		{"abcdef", 6, "abcdef"},
+		{"abc", 10, "abc"},
+		{"", 3, ""},
+		{long, 1, "9"},
+		{long, 40000, long[len(long)-40000:]},
+		{long, 99999, long[1:]},
+	}
+	for _, c := range cases {
+		readers := []io.Reader{strings.NewReader(c.in), iotest.OneByteReader(strings.NewReader(c.in))}
+		for _, r := range readers {
+			var out bytes.Buffer
+			if err := tailBytes(r, &out, c.n); err != nil {
+				t.Errorf("tailBytes(%d bytes, %d) error: %v", len(c.in), c.n, err)
+			}
+			if out.String() != c.out {
+				t.Errorf("tailBytes(%d bytes, %d) = %d bytes, want %d",
+					len(c.in), c.n, out.Len(), len(c.out))
+			}
+		}
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "log")
+	ioutil.WriteFile(name, []byte("one\n"), 0644)
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := file.Stat()
+	fl := &followed{name: name, file: file, info: fi, offset: 4}
+	file.Seek(4, 0)
+
+	var out bytes.Buffer
+	f := follower{
+		files: []*followed{fl},
+		args:  arg{follow: follow_name},
+		w:     bufio.NewWriter(&out),
+		last:  fl,
+	}
+
+	appendTo := func(s string) {
+		a, _ := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+		a.WriteString(s)
+		a.Close()
+	}
+
+	appendTo("two\n")
+	f.poll()
+
+	// Rotate the log away and start a fresh one in its place
+	os.Rename(name, name+".1")
+	ioutil.WriteFile(name, []byte("three\n"), 0644)
+	f.poll()
+
+	appendTo("four\n")
+	f.poll()
+
+	if want := "two\nthree\nfour\n"; out.String() != want {
+		t.Errorf("followed output = %q, want %q", out.String(), want)
+	}
+	if fl.file != nil {
+		fl.file.Close()
+	}
+}
+
+func TestFollowable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "log")
+	ioutil.WriteFile(name, nil, 0644)
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{name, true},
+		{dir, false},
+		{os.DevNull, true},
+	}
+	for _, c := range cases {
+		fi, err := os.Stat(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := followable(fi.Mode()); got != c.want {
+			t.Errorf("followable(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
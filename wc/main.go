@@ -10,27 +10,85 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/trevorparker/goutils/internal/errmsg"
+	"github.com/trevorparker/goutils/internal/width"
 )
 
 type arg struct {
 	count_bytes           bool
+	count_chars           bool
 	count_lines           bool
 	count_max_line_length bool
 	count_words           bool
+	files0_from           string
+	jobs                  int
+	format                string
 	file                  []string
 }
 
+type counts struct {
+	lines           int64
+	words           int64
+	chars           int64
+	bytes           int64
+	max_line_length int64
+}
+
+type result struct {
+	name   string
+	counts counts
+	err    error
+	info   os.FileInfo // nil if the file couldn't be opened or stat()ed
+}
+
+// One file's counts as written by --format=json. Counts that weren't
+// asked for are left out.
+type record struct {
+	Path          string `json:"path,omitempty"`
+	Lines         *int64 `json:"lines,omitempty"`
+	Words         *int64 `json:"words,omitempty"`
+	Chars         *int64 `json:"chars,omitempty"`
+	Bytes         *int64 `json:"bytes,omitempty"`
+	MaxLineLength *int64 `json:"max_line_length,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
 const (
 	usage_message string = "usage: wc [OPTION ...] [FILE ...]"
 	help_message  string = `Count bytes, lines, or words for FILE or STDIN to STDOUT.
+Counts are printed in the order lines, words, characters, bytes, maximum
+line length. With no options, print lines, words, and bytes. A total is
+printed when multiple files are passed in.
+
+Input is read as UTF-8. Bytes that aren't valid UTF-8 are not counted as
+characters and have no display width, but still form part of a word.
 
   -c, --bytes              count bytes
+  -m, --chars              count characters
   -l, --lines              count newlines
-  -L, --max-line-length    count the length of the longest line
+  -L, --max-line-length    count the display width of the longest line;
+                           tabs stop every 8 columns and East Asian wide
+                           characters take two columns
   -w, --words              count words
+  -j, --jobs=N             count up to N files at once; defaults to the
+                           number of CPUs
+      --format=FORMAT      print counts as FORMAT: text (the default),
+                           json, csv, or tsv; machine-readable formats
+                           always include a total and any errors
+      --files0-from=F      read the names of the files to count from F,
+                           separated by NUL characters; if F is - then
+                           read names from STDIN
   -h, --help               print this help message and exit
 `
 )
@@ -45,54 +103,461 @@ func help() {
 	os.Exit(0)
 }
 
-func wc(file io.Reader, args arg, size int64) int64 {
-	// Default the count to the size passed in (i.e.: stat() on a file)
-	c := size
+// A counter tallies every metric in one pass over its input, carrying
+// enough state between calls to write that words, lines and characters
+// may straddle buffer boundaries.
+type counter struct {
+	counts
+	decode      bool
+	in_word     bool
+	line_length int64
+	pending     []byte
+}
+
+func (c *counter) write(buf []byte) {
+	c.bytes += int64(len(buf))
+
+	// Lines and words can be found without decoding UTF-8, since
+	// every separator is a single ASCII byte
+	if !c.decode {
+		for _, b := range buf {
+			switch b {
+			case '\n':
+				c.lines++
+				c.in_word = false
+			case ' ', '\t', '\v', '\f', '\r':
+				c.in_word = false
+			default:
+				if !c.in_word {
+					c.words++
+					c.in_word = true
+				}
+			}
+		}
+		return
+	}
+
+	// Complete a character that was split across reads
+	for len(c.pending) > 0 && len(buf) > 0 {
+		for len(buf) > 0 && !utf8.FullRune(c.pending) {
+			c.pending = append(c.pending, buf[0])
+			buf = buf[1:]
+		}
+		if !utf8.FullRune(c.pending) {
+			return
+		}
+		c.pending = append([]byte{}, c.scan(c.pending)...)
+	}
+	// What's left of pending may be waiting on the next read
+	if len(buf) == 0 {
+		return
+	}
+
+	c.pending = append(c.pending[:0], c.scan(buf)...)
+}
+
+// Decode and count the characters in p, returning any trailing bytes
+// that could be the start of an incomplete character.
+func (c *counter) scan(p []byte) []byte {
+	for i := 0; i < len(p); {
+		if p[i] < utf8.RuneSelf {
+			c.chars++
+			c.char(rune(p[i]))
+			i++
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			return p[i:]
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		if r == utf8.RuneError && size == 1 {
+			if !c.in_word {
+				c.words++
+				c.in_word = true
+			}
+		} else {
+			c.chars++
+			c.char(r)
+		}
+		i += size
+	}
+	return nil
+}
+
+func (c *counter) char(r rune) {
+	switch r {
+	case '\n':
+		c.lines++
+		c.endLine()
+		c.in_word = false
+	case '\r', '\f':
+		c.endLine()
+		c.in_word = false
+	case '\t':
+		c.line_length += 8 - c.line_length%8
+		c.in_word = false
+	case ' ':
+		c.line_length++
+		c.in_word = false
+	case '\v':
+		c.in_word = false
+	default:
+		c.line_length += int64(width.Rune(r))
+		if !c.in_word {
+			c.words++
+			c.in_word = true
+		}
+	}
+}
+
+func (c *counter) endLine() {
+	if c.line_length > c.max_line_length {
+		c.max_line_length = c.line_length
+	}
+	c.line_length = 0
+}
+
+// Account for a final line that has no trailing newline, and for an
+// incomplete character cut off by the end of the input.
+func (c *counter) finish() counts {
+	if len(c.pending) > 0 && !c.in_word {
+		c.words++
+	}
+	c.endLine()
+	return c.counts
+}
+
+func wc(file io.Reader, args arg, size int64) (counts, error) {
+	var c counter
+
+	// Use the size passed in (i.e.: stat() on a file) when bytes are
+	// all that were asked for
+	if size > 0 && args.count_bytes && !args.count_lines &&
+		!args.count_words && !args.count_chars && !args.count_max_line_length {
+		c.bytes = size
+		return c.finish(), nil
+	}
 
 	if file == nil {
 		file = os.Stdin
 	}
 
-	// Count bytes coming in through STDIN
-	if c == 0 && args.count_bytes {
-		s := bufio.NewScanner(file)
-		s.Split(bufio.ScanBytes)
-		for s.Scan() {
-			c++
+	// Lines and bytes alone don't need the per-byte state machine
+	lines_only := !args.count_words && !args.count_chars &&
+		!args.count_max_line_length
+	c.decode = args.count_chars || args.count_max_line_length
+
+	buf := make([]byte, 256*1024)
+	for {
+		n, err := file.Read(buf)
+		if lines_only {
+			c.bytes += int64(n)
+			c.lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		} else {
+			c.write(buf[:n])
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return c.finish(), err
+		}
+	}
+
+	return c.finish(), nil
+}
+
+// Count the file called name, or STDIN for "-". Errors are carried in
+// the result alongside whatever was counted before they happened.
+func count(name string, args arg) result {
+	if name == "-" {
+		info, _ := os.Stdin.Stat()
+		c, err := wc(nil, args, 0)
+		return result{name, c, err, info}
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return result{name, counts{}, err, nil}
+	}
+	defer file.Close()
+
+	// Call stat() on the file to help byte count performance
+	stat, err := file.Stat()
+	if err != nil {
+		return result{name, counts{}, err, nil}
+	}
+	size := int64(0)
+	if stat.Mode().IsRegular() {
+		size = stat.Size()
+	}
+
+	c, err := wc(file, args, size)
+	return result{name, c, err, stat}
+}
+
+// Print the requested counts for one file in the fixed column order,
+// each right-aligned to width.
+func printCounts(w io.Writer, c counts, name string, width int, args arg) {
+	columns := make([]string, 0)
+	for _, v := range selectCounts(c, args) {
+		columns = append(columns, fmt.Sprintf("%*d", width, v))
+	}
+	if name != "" {
+		columns = append(columns, name)
+	}
+	fmt.Fprintln(w, strings.Join(columns, " "))
+}
+
+// Print one result in wc's usual columns. Files that couldn't be opened
+// at all are left out, while read errors still show partial counts.
+func printText(w io.Writer, r result, width int, args arg) {
+	if e, ok := r.err.(*os.PathError); ok && e.Op == "open" {
+		return
+	}
+	printCounts(w, r.counts, r.name, width, args)
+}
+
+// Size the columns the way coreutils does, before anything is counted:
+// wide enough for the combined size of the regular files, and at least
+// 7 wide if there's anything else, like a pipe, whose size isn't known.
+// A single count of a single file isn't padded at all. Counts that turn
+// out wider than this push the columns out of line, as they do in GNU wc.
+func numberWidth(results []result, args arg) int {
+	if len(results) == 0 || len(results) == 1 && len(selectCounts(counts{}, args)) == 1 {
+		return 1
+	}
+
+	minimum := 1
+	regular_total := int64(0)
+	for _, r := range results {
+		if r.info == nil {
+			continue
+		}
+		if r.info.Mode().IsRegular() {
+			regular_total += r.info.Size()
+		} else {
+			minimum = 7
+		}
+	}
+
+	width := len(strconv.FormatInt(regular_total, 10))
+	if width < minimum {
+		width = minimum
+	}
+	return width
+}
+
+func newRecord(path string, c counts, err error, args arg) record {
+	rec := record{Path: path}
+	if args.count_lines {
+		rec.Lines = &c.lines
+	}
+	if args.count_words {
+		rec.Words = &c.words
+	}
+	if args.count_chars {
+		rec.Chars = &c.chars
+	}
+	if args.count_bytes {
+		rec.Bytes = &c.bytes
+	}
+	if args.count_max_line_length {
+		rec.MaxLineLength = &c.max_line_length
+	}
+	if err != nil {
+		rec.Error = errmsg.Describe(err)
+	}
+	return rec
+}
+
+// Print results as a JSON object holding a record for each file and one
+// for the total.
+func printJSON(w io.Writer, results []result, total counts, args arg) error {
+	out := struct {
+		Files []record `json:"files"`
+		Total record   `json:"total"`
+	}{make([]record, 0), newRecord("", total, nil, args)}
+	for _, r := range results {
+		out.Files = append(out.Files, newRecord(resultPath(r), r.counts, r.err, args))
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(out)
+}
+
+// Print results as comma- or tab-separated records under a header row,
+// with the total last.
+func printDelimited(w io.Writer, results []result, total counts, args arg, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	header := append([]string{"path"}, selectNames(args)...)
+	cw.Write(append(header, "error"))
+
+	row := func(path string, c counts, err error) {
+		fields := []string{path}
+		for _, v := range selectCounts(c, args) {
+			fields = append(fields, strconv.FormatInt(v, 10))
+		}
+		msg := ""
+		if err != nil {
+			msg = errmsg.Describe(err)
 		}
+		cw.Write(append(fields, msg))
+	}
+	for _, r := range results {
+		row(resultPath(r), r.counts, r.err)
+	}
+	row("total", total, nil)
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// The path to show for a result; STDIN is named "-".
+func resultPath(r result) string {
+	if r.name == "" {
+		return "-"
+	}
+	return r.name
+}
+
+// The field names of the counts returned by selectCounts.
+func selectNames(args arg) []string {
+	names := make([]string, 0)
+	if args.count_lines {
+		names = append(names, "lines")
+	}
+	if args.count_words {
+		names = append(names, "words")
+	}
+	if args.count_chars {
+		names = append(names, "chars")
+	}
+	if args.count_bytes {
+		names = append(names, "bytes")
+	}
+	if args.count_max_line_length {
+		names = append(names, "max_line_length")
 	}
+	return names
+}
 
-	// Count lines or words on STDIN or in the file passed in
-	if args.count_lines && !args.count_bytes {
-		c = 0
-		s := bufio.NewScanner(file)
-		s.Split(bufio.ScanLines)
-		for s.Scan() {
-			c++
+func selectCounts(c counts, args arg) []int64 {
+	selected := make([]int64, 0)
+	if args.count_lines {
+		selected = append(selected, c.lines)
+	}
+	if args.count_words {
+		selected = append(selected, c.words)
+	}
+	if args.count_chars {
+		selected = append(selected, c.chars)
+	}
+	if args.count_bytes {
+		selected = append(selected, c.bytes)
+	}
+	if args.count_max_line_length {
+		selected = append(selected, c.max_line_length)
+	}
+	return selected
+}
+
+func parse_args(args []string, i *int, s string, l string) (arg_v string) {
+	if args[*i] == s || args[*i] == l {
+		if len(args)-1 > *i {
+			*i++
+			return args[*i]
 		}
-	} else if args.count_max_line_length && !args.count_bytes {
-		c = 0
-		s := bufio.NewScanner(file)
-		for s.Scan() {
-			line_length := int64(len(s.Text()))
-			if line_length > c {
-				c = line_length
+		usage("option requires value -- " + args[*i])
+	}
+	if strings.HasPrefix(args[*i], l+"=") {
+		return strings.TrimPrefix(args[*i], l+"=")
+	}
+	if strings.HasPrefix(args[*i], s) {
+		return strings.TrimPrefix(args[*i], s)
+	}
+	return ""
+}
+
+// Read the NUL-separated file names in r, as written by find -print0.
+// The final name need not be terminated.
+func readFiles0(r io.Reader) ([]string, error) {
+	names := make([]string, 0)
+	br := bufio.NewReader(r)
+	for {
+		name, err := br.ReadString(0)
+		if err == io.EOF {
+			if name != "" {
+				names = append(names, name)
 			}
+			return names, nil
+		} else if err != nil {
+			return names, err
 		}
-	} else if args.count_words && !args.count_bytes {
-		c = 0
-		s := bufio.NewScanner(file)
-		s.Split(bufio.ScanWords)
-		for s.Scan() {
-			c++
+		names = append(names, strings.TrimSuffix(name, "\x00"))
+	}
+}
+
+// Count every named file using up to jobs workers, handing each result
+// to emit in the same order as names as soon as it and every result
+// before it are done, whatever order the workers finish in.
+func countAll(names []string, args arg, jobs int, emit func(result)) {
+	done := make([]chan result, len(names))
+	for i := range done {
+		done[i] = make(chan result, 1)
+	}
+
+	work := make(chan int)
+	for j := 0; j < jobs; j++ {
+		go func() {
+			for i := range work {
+				done[i] <- count(names[i], args)
+			}
+		}()
+	}
+	go func() {
+		for i := range names {
+			if names[i] != "-" {
+				work <- i
+			}
 		}
+		close(work)
+	}()
+
+	// STDIN can't be shared between workers, so count it on its own
+	go func() {
+		for i := range names {
+			if names[i] == "-" {
+				done[i] <- count(names[i], args)
+			}
+		}
+	}()
+
+	for i := range done {
+		emit(<-done[i])
 	}
+}
 
-	return c
+// Stat each named file up front, the way count will find it, so the
+// columns can be sized before anything has been counted.
+func statAll(names []string) []result {
+	results := make([]result, len(names))
+	for i, name := range names {
+		results[i].name = name
+		if name == "-" {
+			results[i].info, _ = os.Stdin.Stat()
+		} else {
+			results[i].info, _ = os.Stat(name)
+		}
+	}
+	return results
 }
 
 func main() {
-	args := arg{false, false, false, false, []string{}}
+	args := arg{false, false, false, false, false, "", runtime.GOMAXPROCS(0), "text", []string{}}
 	reached_files := false
 	for i := 1; i < len(os.Args); i++ {
 		if reached_files == false {
@@ -103,6 +568,10 @@ func main() {
 				args.count_bytes = true
 				continue
 			}
+			if os.Args[i] == "-m" || os.Args[i] == "--chars" {
+				args.count_chars = true
+				continue
+			}
 			if os.Args[i] == "-l" || os.Args[i] == "--lines" {
 				args.count_lines = true
 				continue
@@ -115,32 +584,144 @@ func main() {
 				args.count_words = true
 				continue
 			}
+			if arg_v := parse_args(os.Args, &i, "-j", "--jobs"); arg_v != "" {
+				jobs, err := strconv.Atoi(arg_v)
+				if err != nil || jobs < 1 {
+					usage("invalid number of jobs -- " + arg_v)
+				}
+				args.jobs = jobs
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "--format", "--format"); arg_v != "" {
+				if arg_v != "text" && arg_v != "json" && arg_v != "csv" && arg_v != "tsv" {
+					usage("invalid format -- " + arg_v)
+				}
+				args.format = arg_v
+				continue
+			}
+			if arg_v := parse_args(os.Args, &i, "--files0-from", "--files0-from"); arg_v != "" {
+				args.files0_from = arg_v
+				continue
+			}
 		}
 		arg_v := os.Args[i]
 		reached_files = true
 		args.file = append(args.file, arg_v)
 	}
 
-	if len(args.file) == 0 {
-		count := wc(nil, args, 0)
-		fmt.Fprintf(os.Stdout, "%d\n", count)
+	if !args.count_bytes && !args.count_chars && !args.count_lines &&
+		!args.count_max_line_length && !args.count_words {
+		args.count_lines = true
+		args.count_words = true
+		args.count_bytes = true
 	}
 
-	for i := range args.file {
-		if args.file[i] == "-" {
-			count := wc(nil, args, 0)
-			fmt.Fprintf(os.Stdout, "%d\n", count)
-		} else {
-			// Call stat() on the file to help byte count performance
-			file, err := os.Open(args.file[i])
-			stat, err := file.Stat()
-			size := stat.Size()
+	status := 0
+	if args.files0_from != "" {
+		if len(args.file) > 0 {
+			usage("extra operand " + args.file[0] +
+				"; file operands cannot be combined with --files0-from")
+		}
+		var list io.Reader = os.Stdin
+		if args.files0_from != "-" {
+			f, err := os.Open(args.files0_from)
 			if err != nil {
-				panic(err)
+				fmt.Fprintf(os.Stderr, "wc: cannot open %s for reading: %s\n",
+					args.files0_from, errmsg.Describe(err))
+				os.Exit(1)
 			}
+			defer f.Close()
+			list = f
+		}
+		names, err := readFiles0(list)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wc: %s: %s\n", args.files0_from, errmsg.Describe(err))
+			os.Exit(1)
+		}
+		args.file = names
+	}
+
+	names := make([]string, 0)
+	for i := range args.file {
+		if args.file[i] == "" && args.files0_from != "" {
+			fmt.Fprintf(os.Stderr, "wc: %s:%d: invalid zero-length file name\n",
+				args.files0_from, i+1)
+			status = 1
+			continue
+		} else if args.file[i] == "" {
+			fmt.Fprintln(os.Stderr, "wc: invalid zero-length file name")
+			status = 1
+			continue
+		}
+		if args.file[i] == "-" && args.files0_from == "-" {
+			fmt.Fprintln(os.Stderr, "wc: when reading file names from stdin, no file name of '-' allowed")
+			status = 1
+			continue
+		}
+		names = append(names, args.file[i])
+	}
+
+	// STDIN is read when there's nothing else, with no name shown
+	implicit := len(args.file) == 0 && args.files0_from == ""
+	if implicit {
+		names = append(names, "-")
+	}
+
+	// Size the columns before anything is counted, so each file can be
+	// printed as soon as it's done
+	width := numberWidth(statAll(names), args)
+
+	w := bufio.NewWriter(os.Stdout)
+	results := make([]result, 0)
+	var total counts
+	countAll(names, args, args.jobs, func(r result) {
+		if implicit {
+			r.name = ""
+		}
+
+		// Report failures on STDERR, whatever the output format
+		if r.err != nil {
+			fmt.Fprintf(os.Stderr, "wc: %s: %s\n", resultPath(r), errmsg.Describe(r.err))
+			status = 1
+		}
+
+		total.lines += r.counts.lines
+		total.words += r.counts.words
+		total.chars += r.counts.chars
+		total.bytes += r.counts.bytes
+		if r.counts.max_line_length > total.max_line_length {
+			total.max_line_length = r.counts.max_line_length
+		}
 
-			count := wc(file, args, size)
-			fmt.Fprintf(os.Stdout, "%d %s\n", count, args.file[i])
+		// The other formats are whole documents, written once
+		// everything has been counted
+		if args.format == "text" {
+			printText(w, r, width, args)
+			w.Flush()
+		} else {
+			results = append(results, r)
+		}
+	})
+
+	var err error
+	switch args.format {
+	case "json":
+		err = printJSON(w, results, total, args)
+	case "csv":
+		err = printDelimited(w, results, total, args, ',')
+	case "tsv":
+		err = printDelimited(w, results, total, args, '\t')
+	default:
+		if len(args.file) > 1 {
+			printCounts(w, total, "total", width, args)
 		}
 	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wc: write error: %s\n", errmsg.Describe(err))
+		status = 1
+	}
+	os.Exit(status)
 }
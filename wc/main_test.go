@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var all = arg{count_bytes: true, count_chars: true, count_lines: true, count_words: true, count_max_line_length: true}
+
+func TestCounts(t *testing.T) {
+	cases := []struct {
+		in   string
+		want counts
+	}{
+		{"", counts{0, 0, 0, 0, 0}},
+		{"hello world\n", counts{1, 2, 12, 12, 11}},
+		{"hello world", counts{0, 2, 11, 11, 11}},
+		{"  a\tb \n\n c\r\n", counts{3, 3, 12, 12, 10}},
+		{"one\ntwo three\nfour", counts{2, 4, 18, 18, 9}},
+		{"日本語\n", counts{1, 1, 4, 10, 6}},
+		{"e\u0301t\u00e9\n", counts{1, 1, 5, 7, 3}},
+		{"\xff\xfe a\n", counts{1, 2, 3, 5, 2}},
+		{"\t\xe6\x97\n", counts{1, 1, 2, 4, 8}},
+	}
+	for _, c := range cases {
+		if got, _ := wc(strings.NewReader(c.in), all, 0); got != c.want {
+			t.Errorf("wc(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+		lines, _ := wc(strings.NewReader(c.in), arg{count_lines: true}, 0)
+		if lines.lines != c.want.lines || lines.bytes != c.want.bytes {
+			t.Errorf("wc -l (%q) = %+v, want %+v", c.in, lines, c.want)
+		}
+	}
+}
+
+// Words and lines split across reads must be counted once.
+func TestCountsAcrossReads(t *testing.T) {
+	in := strings.Repeat("word ", 100000) + "\n" + strings.Repeat("x", 300000)
+	got, _ := wc(strings.NewReader(in), all, 0)
+	want := counts{1, 100001, int64(len(in)), int64(len(in)), 500000}
+	if got != want {
+		t.Errorf("wc = %+v, want %+v", got, want)
+	}
+}
+
+// Lines far longer than bufio.Scanner's 64 KiB token limit must be
+// counted in full.
+func TestLongLines(t *testing.T) {
+	for _, size := range []int{64 * 1024, 1 << 20, 16 << 20} {
+		line := strings.Repeat("a", size/2) + " " + strings.Repeat("日", size/6)
+		in := line + "\n" + line
+		got, err := wc(strings.NewReader(in), all, 0)
+		if err != nil {
+			t.Errorf("wc(%d byte lines) error: %v", len(line), err)
+		}
+		width := int64(size/2 + 1 + 2*(size/6))
+		chars := int64(size/2 + 1 + size/6)
+		want := counts{1, 4, 2*chars + 1, int64(len(in)), width}
+		if got != want {
+			t.Errorf("wc(%d byte lines) = %+v, want %+v", len(line), got, want)
+		}
+
+		lines, err := wc(strings.NewReader(in), arg{count_lines: true}, 0)
+		if err != nil || lines.lines != 1 {
+			t.Errorf("wc -l (%d byte lines) = %d, %v, want 1", len(line), lines.lines, err)
+		}
+	}
+}
+
+type failingReader struct {
+	remaining int
+}
+
+var errRead = errors.New("input/output error")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, errRead
+	}
+	n := copy(p, strings.Repeat("x\n", r.remaining))
+	r.remaining -= n / 2
+	return n, nil
+}
+
+func TestReadError(t *testing.T) {
+	got, err := wc(&failingReader{10}, all, 0)
+	if err != errRead {
+		t.Errorf("wc error = %v, want %v", err, errRead)
+	}
+	if got.lines != 10 {
+		t.Errorf("wc counted %d lines before the error, want 10", got.lines)
+	}
+}
+
+func TestCountErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if r := count(filepath.Join(dir, "missing"), all); !os.IsNotExist(r.err) {
+		t.Errorf("count(missing) error = %v, want not exist", r.err)
+	}
+	if r := count(dir, arg{count_bytes: true}); r.err == nil {
+		t.Errorf("count(directory) succeeded, want an error")
+	}
+
+	name := filepath.Join(dir, "file")
+	ioutil.WriteFile(name, []byte("one two\n"), 0644)
+	if r := count(name, all); r.err != nil || r.counts.words != 2 {
+		t.Errorf("count(file) = %+v, want 2 words and no error", r)
+	}
+}
+
+func TestCountAllOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Make files of very different sizes so workers finish out of order
+	names := make([]string, 0)
+	for i := 0; i < 50; i++ {
+		name := filepath.Join(dir, strconv.Itoa(i))
+		ioutil.WriteFile(name, []byte(strings.Repeat("x\n", (i%7)*10000+i)), 0644)
+		names = append(names, name)
+	}
+
+	for _, jobs := range []int{1, 4, 16} {
+		results := make([]result, 0)
+		countAll(names, all, jobs, func(r result) {
+			results = append(results, r)
+		})
+		if len(results) != len(names) {
+			t.Fatalf("jobs=%d: got %d results, want %d", jobs, len(results), len(names))
+		}
+		for i, r := range results {
+			want := int64((i%7)*10000 + i)
+			if r.name != names[i] || r.counts.lines != want {
+				t.Errorf("jobs=%d: result %d = %s with %d lines, want %s with %d",
+					jobs, i, r.name, r.counts.lines, names[i], want)
+			}
+		}
+	}
+}
+
+func TestFormats(t *testing.T) {
+	args := arg{count_lines: true, count_bytes: true}
+	results := []result{
+		{name: "a, b", counts: counts{lines: 2, bytes: 10}},
+		{name: "", counts: counts{lines: 1, bytes: 4}},
+		{name: "missing", err: &os.PathError{Op: "open", Path: "missing", Err: os.ErrNotExist}},
+	}
+	total := counts{lines: 3, bytes: 14}
+
+	var out bytes.Buffer
+	printDelimited(&out, results, total, args, ',')
+	want := "path,lines,bytes,error\n" +
+		"\"a, b\",2,10,\n" +
+		"-,1,4,\n" +
+		"missing,0,0,File does not exist\n" +
+		"total,3,14,\n"
+	if out.String() != want {
+		t.Errorf("csv output = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	printJSON(&out, results, total, args)
+	var decoded struct {
+		Files []map[string]interface{}
+		Total map[string]interface{}
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("json output %q doesn't parse: %v", out.String(), err)
+	}
+	if len(decoded.Files) != 3 || decoded.Files[0]["path"] != "a, b" ||
+		decoded.Files[0]["lines"] != 2.0 || decoded.Files[1]["path"] != "-" ||
+		decoded.Files[2]["error"] != "File does not exist" || decoded.Total["bytes"] != 14.0 {
+		t.Errorf("json output = %s", out.String())
+	}
+	if _, ok := decoded.Files[0]["words"]; ok {
+		t.Errorf("json output includes words, which weren't asked for: %s", out.String())
+	}
+}
+
+// A stand-in for the os.FileInfo of an input, which is all numberWidth
+// looks at to size the columns.
+type fakeInfo struct {
+	size int64
+	mode os.FileMode
+}
+
+func (f fakeInfo) Name() string       { return "" }
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeInfo) ModTime() time.Time { return time.Time{} }
+func (f fakeInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeInfo) Sys() interface{}   { return nil }
+
+func TestPrintText(t *testing.T) {
+	regular := func(size int64) os.FileInfo { return fakeInfo{size: size} }
+	pipe := fakeInfo{mode: os.ModeNamedPipe}
+	device := fakeInfo{mode: os.ModeDevice | os.ModeCharDevice}
+	missing := &os.PathError{Op: "open", Path: "missing", Err: os.ErrNotExist}
+	f2 := counts{lines: 2, words: 3, chars: 6, bytes: 6, max_line_length: 3}
+	f3 := counts{lines: 2, words: 3, chars: 14, bytes: 16, max_line_length: 11}
+	lines := arg{count_lines: true}
+	standard := arg{count_lines: true, count_words: true, count_bytes: true}
+
+	// The output GNU wc gives for the same inputs
+	cases := []struct {
+		results    []result
+		args       arg
+		show_total bool
+		out        string
+	}{
+		{[]result{{name: "f2", counts: f2, info: regular(6)}}, standard, false, "2 3 6 f2\n"},
+		{[]result{{name: "", counts: f2, info: regular(6)}}, standard, false, "2 3 6\n"},
+		{[]result{{name: "", counts: f2, info: pipe}}, standard, false, "      2       3       6\n"},
+		{[]result{{name: "", counts: f2, info: pipe}}, lines, false, "2\n"},
+		{[]result{{name: "f1", counts: counts{lines: 1755}, info: regular(135091)}}, lines, false, "1755 f1\n"},
+		{[]result{{name: "/dev/null", info: device}}, standard, false, "      0       0       0 /dev/null\n"},
+		{
+			[]result{
+				{name: "f2", counts: f2, info: regular(6)},
+				{name: "f1", counts: counts{lines: 1755}, info: regular(135091)},
+			},
+			lines, true,
+			"     2 f2\n  1755 f1\n  1757 total\n",
+		},
+		{
+			[]result{
+				{name: "f3", counts: f3, info: regular(16)},
+				{name: "f2", counts: f2, info: regular(6)},
+			},
+			all, true,
+			" 2  3 14 16 11 f3\n 2  3  6  6  3 f2\n 4  6 20 22 11 total\n",
+		},
+		{
+			[]result{
+				{name: "f2", counts: f2, info: regular(6)},
+				{name: "-", counts: f2, info: pipe},
+			},
+			standard, true,
+			"      2       3       6 f2\n      2       3       6 -\n      4       6      12 total\n",
+		},
+		{
+			[]result{
+				{name: "missing", err: missing},
+				{name: "f1", counts: counts{lines: 1755, words: 1755, bytes: 135091}, info: regular(135091)},
+			},
+			standard, true,
+			"  1755   1755 135091 f1\n  1755   1755 135091 total\n",
+		},
+		{
+			[]result{
+				{name: "f1", counts: counts{lines: 1755, words: 1755, bytes: 135091}, info: regular(135091)},
+				{name: "missing", err: missing},
+			},
+			standard, true,
+			"  1755   1755 135091 f1\n  1755   1755 135091 total\n",
+		},
+	}
+	for _, c := range cases {
+		var total counts
+		for _, r := range c.results {
+			total.lines += r.counts.lines
+			total.words += r.counts.words
+			total.chars += r.counts.chars
+			total.bytes += r.counts.bytes
+			if r.counts.max_line_length > total.max_line_length {
+				total.max_line_length = r.counts.max_line_length
+			}
+		}
+		var out bytes.Buffer
+		width := numberWidth(c.results, c.args)
+		for _, r := range c.results {
+			printText(&out, r, width, c.args)
+		}
+		if c.show_total {
+			printCounts(&out, total, "total", width, c.args)
+		}
+		if out.String() != c.out {
+			t.Errorf("printText(%+v) =\n%q\nwant\n%q", c.results, out.String(), c.out)
+		}
+	}
+}
+
+func TestRunesAcrossWrites(t *testing.T) {
+	cases := []struct {
+		in   string
+		want counts
+	}{
+		{"日本\xe8a語 \xf0\x9f\x98", counts{0, 2, 5, 15, 8}},
+		// An invalid byte followed by the lead byte of a character
+		{"\xe4\xe4\xb8\x80", counts{0, 1, 1, 4, 2}},
+		{"\xe4\xe4\xe4\xb8\x80\n", counts{1, 1, 2, 6, 2}},
+	}
+	for _, c := range cases {
+		in := []byte(c.in)
+		whole := counter{decode: true}
+		whole.write(in)
+		if got := whole.finish(); got != c.want {
+			t.Errorf("counts of %q = %+v, want %+v", c.in, got, c.want)
+		}
+
+		split := counter{decode: true}
+		for i := range in {
+			split.write(in[i : i+1])
+		}
+		if got := split.finish(); got != c.want {
+			t.Errorf("byte-at-a-time counts of %q = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestReadFiles0(t *testing.T) {
+	cases := []struct {
+		in    string
+		names []string
+	}{
+		{"", []string{}},
+		{"a\x00", []string{"a"}},
+		{"a\x00b", []string{"a", "b"}},
+		{"a b\x00\x00c\nd\x00", []string{"a b", "", "c\nd"}},
+	}
+	for _, c := range cases {
+		names, err := readFiles0(strings.NewReader(c.in))
+		if err != nil {
+			t.Errorf("readFiles0(%q) error: %v", c.in, err)
+		}
+		if strings.Join(names, "|") != strings.Join(c.names, "|") || len(names) != len(c.names) {
+			t.Errorf("readFiles0(%q) = %q, want %q", c.in, names, c.names)
+		}
+	}
+}
+
+// An endless stream of short lines of text.
+type textReader struct {
+	pos int
+}
+
+const text = "The quick brown fox jumps over the lazy dog.\n"
+
+var block = []byte(strings.Repeat(text, 4096))
+
+func (r *textReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], block[r.pos:])
+		n += c
+		r.pos = (r.pos + c) % len(text)
+	}
+	return n, nil
+}
+
+// The scanner-based counting wc used before, which needs one pass over
+// the input for each metric. Kept here to benchmark against.
+func scanCount(file io.Reader, split bufio.SplitFunc) (c int64) {
+	s := bufio.NewScanner(file)
+	s.Split(split)
+	for s.Scan() {
+		c++
+	}
+	return c
+}
+
+var sizes = []int64{64 << 20, 2 << 30}
+
+func BenchmarkWc(b *testing.B) {
+	for _, size := range sizes {
+		b.Run(strconv.FormatInt(size, 10), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				wc(io.LimitReader(&textReader{}, size), all, 0)
+			}
+		})
+	}
+}
+
+func BenchmarkWcLines(b *testing.B) {
+	for _, size := range sizes {
+		b.Run(strconv.FormatInt(size, 10), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				wc(io.LimitReader(&textReader{}, size), arg{count_lines: true}, 0)
+			}
+		})
+	}
+}
+
+// Lines, words and bytes with the old scanners, one pass each.
+func BenchmarkScanner(b *testing.B) {
+	for _, size := range sizes {
+		b.Run(strconv.FormatInt(size, 10), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				scanCount(io.LimitReader(&textReader{}, size), bufio.ScanLines)
+				scanCount(io.LimitReader(&textReader{}, size), bufio.ScanWords)
+				scanCount(io.LimitReader(&textReader{}, size), bufio.ScanBytes)
+			}
+		})
+	}
+}
+
+func BenchmarkScannerLines(b *testing.B) {
+	for _, size := range sizes {
+		b.Run(strconv.FormatInt(size, 10), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				scanCount(io.LimitReader(&textReader{}, size), bufio.ScanLines)
+			}
+		})
+	}
+}